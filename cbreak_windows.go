@@ -0,0 +1,11 @@
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// cbreak is not supported on Windows, keys are read a line at a time.
+func cbreak(f *os.File) (restore func(), err error) {
+	return nil, errors.New("not supported on Windows")
+}
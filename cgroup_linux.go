@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupManager places every run in a transient cgroup v2 below our own
+// cgroup, limited by --memory-limit and --cpu-limit.
+type cgroupManager struct {
+	limits cgroupLimits
+	parent string
+	leaf   string // where we moved ourselves, if we had to
+
+	mu sync.Mutex
+	n  int
+}
+
+func newCgroupManager(limits cgroupLimits) (*cgroupManager, error) {
+	if !limits.set() {
+		return nil, nil
+	}
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return nil, errors.New("--memory-limit and --cpu-limit need cgroup v2 mounted at " + cgroupRoot)
+	}
+	own, err := ownCgroup()
+	if err != nil {
+		return nil, err
+	}
+	m := &cgroupManager{limits: limits, parent: filepath.Join(cgroupRoot, own)}
+
+	// Controllers can only be enabled for child cgroups of a cgroup
+	// without processes of its own, so move into a leaf first if needed.
+	err = m.enableControllers()
+	if errors.Is(err, syscall.EBUSY) {
+		if err := m.moveToLeaf(); err != nil {
+			return nil, fmt.Errorf("cgroup: moving into a cgroup of our own: %w", err)
+		}
+		if err = m.enableControllers(); errors.Is(err, syscall.EBUSY) {
+			m.close()
+			return nil, fmt.Errorf("cgroup: other processes share %s; start on_change in a cgroup of its own, as with systemd-run --user --scope", m.parent)
+		}
+	}
+	if err != nil {
+		m.close()
+		return nil, fmt.Errorf("cgroup: %w", err)
+	}
+	return m, nil
+}
+
+// moveToLeaf moves this process into a new child cgroup of parent.
+func (m *cgroupManager) moveToLeaf() error {
+	leaf := filepath.Join(m.parent, fmt.Sprintf("on_change-%d", os.Getpid()))
+	if err := os.Mkdir(leaf, 0o755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(leaf, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0); err != nil {
+		os.Remove(leaf)
+		return err
+	}
+	m.leaf = leaf
+	return nil
+}
+
+// close moves this process back out of the leaf cgroup it created and
+// removes it. The controllers have to be disabled again first, a cgroup
+// delegating them cannot hold processes.
+func (m *cgroupManager) close() {
+	if m == nil || m.leaf == "" {
+		return
+	}
+	os.WriteFile(filepath.Join(m.parent, "cgroup.subtree_control"), []byte(m.controllers("-")), 0)
+	if err := os.WriteFile(filepath.Join(m.parent, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0); err != nil {
+		warnf("Warning: cgroup: leaving %s: %v\n", m.leaf, err)
+		return
+	}
+	if err := os.Remove(m.leaf); err != nil {
+		warnf("Warning: cgroup: %v\n", err)
+	}
+	m.leaf = ""
+}
+
+// ownCgroup returns the cgroup v2 path of this process.
+func ownCgroup() (string, error) {
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if path, ok := strings.CutPrefix(scanner.Text(), "0::"); ok {
+			return path, nil
+		}
+	}
+	return "", errors.New("cgroup: not in a cgroup v2 hierarchy")
+}
+
+// controllers returns the controllers the limits need, as "+memory +cpu"
+// with op "+".
+func (m *cgroupManager) controllers(op string) string {
+	var controllers []string
+	if m.limits.memory > 0 {
+		controllers = append(controllers, op+"memory")
+	}
+	if m.limits.cpu > 0 {
+		controllers = append(controllers, op+"cpu")
+	}
+	return strings.Join(controllers, " ")
+}
+
+func (m *cgroupManager) enableControllers() error {
+	controllers := m.controllers("+")
+	err := os.WriteFile(filepath.Join(m.parent, "cgroup.subtree_control"), []byte(controllers), 0)
+	if errors.Is(err, syscall.ENOENT) {
+		return fmt.Errorf("controllers %s are not available in %s", controllers, m.parent)
+	}
+	return err
+}
+
+// apply creates the cgroup for one run and makes cmd start in it. The
+// returned function removes the cgroup once the run is over.
+func (m *cgroupManager) apply(cmd *exec.Cmd) (func(), error) {
+	m.mu.Lock()
+	m.n++
+	dir := filepath.Join(m.parent, fmt.Sprintf("on_change-%d-run%d", os.Getpid(), m.n))
+	m.mu.Unlock()
+
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("cgroup: %w", err)
+	}
+	cleanup := func() { removeCgroup(dir) }
+
+	var settings [][2]string
+	if m.limits.memory > 0 {
+		settings = append(settings,
+			[2]string{"memory.max", strconv.FormatInt(int64(m.limits.memory), 10)},
+			[2]string{"memory.swap.max", "0"})
+	}
+	if m.limits.cpu > 0 {
+		const period = 100000
+		settings = append(settings, [2]string{"cpu.max", fmt.Sprintf("%d %d", int(m.limits.cpu*period), period)})
+	}
+	for _, s := range settings {
+		err := os.WriteFile(filepath.Join(dir, s[0]), []byte(s[1]), 0)
+		if err != nil && !(s[0] == "memory.swap.max" && os.IsNotExist(err)) {
+			cleanup()
+			return nil, fmt.Errorf("cgroup: %w", err)
+		}
+	}
+
+	fd, err := os.Open(dir)
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("cgroup: %w", err)
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(fd.Fd())
+	return func() {
+		fd.Close()
+		cleanup()
+	}, nil
+}
+
+// removeCgroup kills whatever the run left behind in dir and removes it.
+func removeCgroup(dir string) {
+	os.WriteFile(filepath.Join(dir, "cgroup.kill"), []byte("1"), 0)
+	for i := 0; i < 50; i++ {
+		if err := os.Remove(dir); err == nil || os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
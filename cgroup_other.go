@@ -0,0 +1,24 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// cgroupManager is only available on Linux.
+type cgroupManager struct{}
+
+func newCgroupManager(limits cgroupLimits) (*cgroupManager, error) {
+	if !limits.set() {
+		return nil, nil
+	}
+	return nil, errors.New("--memory-limit and --cpu-limit are only supported on Linux")
+}
+
+func (m *cgroupManager) apply(cmd *exec.Cmd) (func(), error) {
+	return func() {}, nil
+}
+
+func (m *cgroupManager) close() {}
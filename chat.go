@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultChatTemplate          = ":x: {{.Hostname}}: `{{.Command}}` failed with exit code {{.ExitCode}} after {{.Duration}}{{if .Files}}, changed: {{join .Files \", \"}}{{end}}"
+	defaultChatRecoveredTemplate = ":white_check_mark: {{.Hostname}}: `{{.Command}}` works again"
+)
+
+// chatMessage is what the --chat templates can show.
+type chatMessage struct {
+	Command  string
+	Rule     string
+	ExitCode int
+	Duration string
+	Files    []string
+	Hostname string
+	Time     time.Time
+}
+
+// chatNotifier posts to Slack or Discord incoming webhooks when a run
+// fails, and with --chat-recovered when the next one succeeds again.
+// Other URLs get Slack's payload, which Mattermost and Rocket.Chat
+// understand as well.
+type chatNotifier struct {
+	urls      []string
+	failed    *template.Template
+	recovered *template.Template // nil without --chat-recovered
+}
+
+// parseChatTemplate parses a --chat-template.
+func parseChatTemplate(text string) (*template.Template, error) {
+	return template.New("chat").Funcs(template.FuncMap{"join": strings.Join}).Parse(text)
+}
+
+func newChatNotifier(opts *options) *chatNotifier {
+	if len(opts.chat) == 0 {
+		return nil
+	}
+	n := &chatNotifier{urls: opts.chat}
+	// Checked by validate
+	n.failed, _ = parseChatTemplate(opts.chatTemplate)
+	if opts.chatRecovered {
+		n.recovered, _ = parseChatTemplate(opts.chatRecoveredTemplate)
+	}
+	return n
+}
+
+// finished posts about a run that failed, or succeeded after one that
+// failed.
+func (n *chatNotifier) finished(failed, wasFailing bool, msg chatMessage) {
+	if n == nil {
+		return
+	}
+	t := n.failed
+	if !failed {
+		if !wasFailing || n.recovered == nil {
+			return
+		}
+		t = n.recovered
+	}
+	msg.Hostname, _ = os.Hostname()
+	msg.Time = time.Now()
+	var text strings.Builder
+	if err := t.Execute(&text, msg); err != nil {
+		debugf("chat: %v", err)
+		return
+	}
+	for _, url := range n.urls {
+		key := "text"
+		if strings.Contains(url, "discord") {
+			key = "content"
+		}
+		body, err := json.Marshal(map[string]string{key: text.String()})
+		if err != nil {
+			return
+		}
+		deliverWebhook(url, body)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// clearScreen clears the terminal with ANSI escapes, and its scrollback
+// too if asked to.
+func clearScreen(scrollback bool) {
+	seq := "\033[H\033[2J"
+	if scrollback {
+		seq += "\033[3J"
+	}
+	os.Stdout.WriteString(seq)
+}
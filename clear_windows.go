@@ -0,0 +1,15 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// clearScreen clears the console with cls, which always takes the
+// scrollback along; the console does not understand ANSI escapes
+// unless asked to.
+func clearScreen(scrollback bool) {
+	cmd := exec.Command("cmd", "/c", "cls")
+	cmd.Stdout = os.Stdout
+	cmd.Run()
+}
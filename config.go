@@ -0,0 +1,771 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// config is a --config file. Its top-level keys are flags, by their long
+// name, the paths and command otherwise given on the command line,
+// "include" listing other config files and "rules" mapping rule names
+// to the paths they react to, their command and flags of their own:
+//
+//	r: true
+//	exclude: [vendor/**]
+//	paths: [.]
+//	command: make
+//	rules:
+//	  build:
+//	    paths: ['**/*.go']
+//	    command: go build ./...
+//	    debounce: 500ms
+//	  server:
+//	    paths: [cmd/, internal/]
+//	    command: ./server
+//	    restart: true
+//	    env: {PORT: 8080}
+//
+// Rules with the same "lock" never run at the same time, and "vars"
+// defines the ${name} variables of the commands.
+type config struct {
+	file      string
+	paths     []string
+	pathsFile string // where paths was set, for errors
+	pathsNode *yaml.Node
+	command   string
+	argv      []string
+	vars      map[string]string
+	settings  []setting
+	rules     []configRule
+	profiles  []profile
+}
+
+// setting is a flag given in a config file.
+type setting struct {
+	file  string
+	key   *yaml.Node
+	value *yaml.Node
+}
+
+type configRule struct {
+	file      string
+	name      string
+	node      *yaml.Node
+	paths     []string
+	pathsNode *yaml.Node
+	command   string
+	argv      []string
+	lock      string
+	settings  []setting
+}
+
+// profile is a named selection of rules, for --profile.
+type profile struct {
+	file     string
+	name     string
+	rules    []*yaml.Node
+	settings []setting
+}
+
+// globalOnly are the flags that affect watching as a whole and so
+// cannot differ between rules.
+var globalOnly = map[string]bool{
+	"r": true, "max-depth": true, "no-gitignore": true, "hidden": true,
+	"no-default-ignores": true, "poll": true, "max-watches": true,
+	"follow-symlinks": true, "events": true, "attrib": true, "0": true,
+	"remote": true, "interval": true, "control": true, "user": true,
+	"group": true, "memory-limit": true, "cpu-limit": true,
+	"exit-status": true, "once": true, "exit-on-change": true,
+	"no-initial": true, "initial-if-stale": true, "forward-stdin": true,
+	"rule": true, "quiet": true, "verbose": true, "log-format": true,
+	"c": true, "clear": true, "time-format": true, "log-file": true,
+	"log-max-size": true, "log-keep": true, "tui": true,
+	"run-on-resume": true, "title": true, "livereload": true,
+	"serve": true, "serve-addr": true, "http": true,
+	"ws": true, "allow-origin": true, "grpc": true, "journal": true, "daemon": true,
+	"pidfile": true, "mqtt": true, "mqtt-topic": true,
+}
+
+// configNames are looked for in the working directory and its parents
+// when on_change is run without arguments.
+var configNames = []string{".onchange.yml", ".onchange.yaml"}
+
+// findConfig returns the nearest project config file, or "" if there is
+// none.
+func findConfig() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		for _, name := range configNames {
+			file := filepath.Join(dir, name)
+			if _, err := os.Stat(file); err == nil {
+				return file, nil
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// loadConfig reads a config file with the files it includes, followed
+// by its personal overlay: the same name with .local added before the
+// extension (.onchange.local.yml), meant to be left out of version
+// control.
+//
+// Included files are read in order and before the including file, and
+// later files win: a flag replaces the earlier value, lists included,
+// a rule defined again keeps the keys it does not set and a profile
+// defined again is replaced.
+func loadConfig(file string) (*config, error) {
+	c, err := readConfig(file, nil)
+	if err != nil {
+		return nil, err
+	}
+	ext := filepath.Ext(file)
+	local := strings.TrimSuffix(file, ext) + ".local" + ext
+	if _, err := os.Stat(local); err == nil {
+		overlay, err := readConfig(local, nil)
+		if err != nil {
+			return nil, err
+		}
+		c.merge(overlay)
+	}
+
+	for _, p := range c.profiles {
+		for _, name := range p.rules {
+			if c.rule(name.Value) == nil {
+				return nil, errorAt(p.file, name, "profile %s: no rule %s", p.name, name.Value)
+			}
+		}
+	}
+	return c, nil
+}
+
+// readConfig reads a single config file and those it includes; seen
+// are the files including it, to catch cycles.
+func readConfig(file string, seen []string) (*config, error) {
+	if slices.Contains(seen, file) {
+		return nil, fmt.Errorf("%s: included from itself", file)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	c := &config{file: file}
+	if len(doc.Content) == 0 {
+		return c, nil
+	}
+	var includes []string
+	var includeNode *yaml.Node
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, c.errorf(root, "expected flags and rules")
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch key.Value {
+		case "paths":
+			if c.paths, err = values(file, value); err != nil {
+				return nil, err
+			}
+			c.pathsFile, c.pathsNode = file, value
+		case "command":
+			if c.command, c.argv, err = commandValue(file, value); err != nil {
+				return nil, err
+			}
+		case "vars":
+			if value.Kind != yaml.MappingNode {
+				return nil, c.errorf(value, "vars must map names to values")
+			}
+			c.vars = map[string]string{}
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				k, v := value.Content[j], value.Content[j+1]
+				if v.Kind != yaml.ScalarNode {
+					return nil, c.errorf(v, "vars: %s must be a string", k.Value)
+				}
+				c.vars[k.Value] = v.Value
+			}
+		case "rules":
+			if value.Kind != yaml.MappingNode {
+				return nil, c.errorf(value, "rules must map names to rules")
+			}
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				r, err := c.parseRule(value.Content[j], value.Content[j+1])
+				if err != nil {
+					return nil, err
+				}
+				c.rules = append(c.rules, r)
+			}
+		case "include":
+			names, err := values(c.file, value)
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range names {
+				if !filepath.IsAbs(name) {
+					name = filepath.Join(filepath.Dir(file), name)
+				}
+				includes = append(includes, name)
+			}
+			includeNode = value
+		case "profiles":
+			if value.Kind != yaml.MappingNode {
+				return nil, c.errorf(value, "profiles must map names to profiles")
+			}
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				p, err := c.parseProfile(value.Content[j], value.Content[j+1])
+				if err != nil {
+					return nil, err
+				}
+				c.profiles = append(c.profiles, p)
+			}
+		default:
+			c.settings = append(c.settings, setting{file, key, value})
+		}
+	}
+	if len(includes) == 0 {
+		return c, nil
+	}
+
+	merged := &config{file: file}
+	for _, name := range includes {
+		inc, err := readConfig(name, append(seen, file))
+		if err != nil {
+			return nil, errorAt(file, includeNode, "include: %v", err)
+		}
+		merged.merge(inc)
+	}
+	merged.merge(c)
+	return merged, nil
+}
+
+// merge adds the settings, rules and profiles of a later file to c.
+func (c *config) merge(later *config) {
+	if later.paths != nil {
+		c.paths, c.pathsFile, c.pathsNode = later.paths, later.pathsFile, later.pathsNode
+	}
+	if later.command != "" {
+		c.command, c.argv = later.command, later.argv
+	}
+	for k, v := range later.vars {
+		if c.vars == nil {
+			c.vars = map[string]string{}
+		}
+		c.vars[k] = v
+	}
+	c.settings = mergeSettings(c.settings, later.settings)
+	for _, r := range later.rules {
+		prev := c.rule(r.name)
+		if prev == nil {
+			c.rules = append(c.rules, r)
+			continue
+		}
+		prev.file, prev.node = r.file, r.node
+		if r.paths != nil {
+			prev.paths, prev.pathsNode = r.paths, r.pathsNode
+		}
+		if r.command != "" {
+			prev.command, prev.argv = r.command, r.argv
+		}
+		if r.lock != "" {
+			prev.lock = r.lock
+		}
+		prev.settings = mergeSettings(prev.settings, r.settings)
+	}
+	for _, p := range later.profiles {
+		i := slices.IndexFunc(c.profiles, func(prev profile) bool { return prev.name == p.name })
+		if i < 0 {
+			c.profiles = append(c.profiles, p)
+		} else {
+			c.profiles[i] = p
+		}
+	}
+}
+
+// mergeSettings returns settings with those of later replacing the ones
+// for the same flag.
+func mergeSettings(settings, later []setting) []setting {
+	settings = slices.Clone(settings)
+	for _, s := range later {
+		i := slices.IndexFunc(settings, func(prev setting) bool { return prev.key.Value == s.key.Value })
+		if i < 0 {
+			settings = append(settings, s)
+		} else {
+			settings[i] = s
+		}
+	}
+	return settings
+}
+
+// parseProfile reads a profile, either a list of rule names or the
+// rules together with flags overriding the top-level ones:
+//
+//	profiles:
+//	  build: [build]
+//	  dev:
+//	    rules: [build, server]
+//	    restart: true
+func (c *config) parseProfile(name, node *yaml.Node) (profile, error) {
+	p := profile{file: c.file, name: name.Value}
+	switch node.Kind {
+	case yaml.SequenceNode:
+		p.rules = node.Content
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value != "rules" {
+				p.settings = append(p.settings, setting{c.file, key, value})
+				continue
+			}
+			if value.Kind != yaml.SequenceNode {
+				return p, c.errorf(value, "profile %s: rules must be a list of rule names", p.name)
+			}
+			p.rules = value.Content
+		}
+	default:
+		return p, c.errorf(node, "profile %s: expected a list of rule names", p.name)
+	}
+	return p, nil
+}
+
+// rule returns the rule called name, or nil.
+func (c *config) rule(name string) *configRule {
+	for i := range c.rules {
+		if c.rules[i].name == name {
+			return &c.rules[i]
+		}
+	}
+	return nil
+}
+
+func (c *config) parseRule(name, node *yaml.Node) (configRule, error) {
+	r := configRule{file: c.file, name: name.Value, node: name}
+	if node.Kind != yaml.MappingNode {
+		return r, c.errorf(node, "rule %s: expected paths, command and flags", r.name)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		switch key.Value {
+		case "paths":
+			paths, err := values(c.file, value)
+			if err != nil {
+				return r, err
+			}
+			r.paths, r.pathsNode = paths, value
+		case "command":
+			var err error
+			if r.command, r.argv, err = commandValue(c.file, value); err != nil {
+				return r, err
+			}
+		case "lock":
+			if value.Kind != yaml.ScalarNode {
+				return r, c.errorf(value, "rule %s: lock must be a name", r.name)
+			}
+			r.lock = value.Value
+		default:
+			if globalOnly[key.Value] {
+				return r, c.errorf(key, "rule %s: %s can only be set at the top level", r.name, key.Value)
+			}
+			r.settings = append(r.settings, setting{c.file, key, value})
+		}
+	}
+	return r, nil
+}
+
+// set applies the setting to the flags of fs. Lists set a repeatable
+// flag once per item and mappings as KEY=VALUE, for env.
+func (s setting) set(fs *flag.FlagSet) error {
+	if fs.Lookup(s.key.Value) == nil {
+		return errorAt(s.file, s.key, "unknown flag %q", s.key.Value)
+	}
+	values, err := values(s.file, s.value)
+	if err != nil {
+		return err
+	}
+	for _, v := range values {
+		if err := fs.Set(s.key.Value, v); err != nil {
+			return errorAt(s.file, s.value, "%s: %v", s.key.Value, err)
+		}
+	}
+	return nil
+}
+
+// variables returns the values of ${name} in commands: the config's vars,
+// unless the environment has them, and the built-in ${root}, the
+// directory of the config file. ${rule} is the rule's name, set per rule
+// and empty in the top-level command, and ${file} stands for the {file}
+// placeholder.
+func (c *config) variables() map[string]string {
+	vars := map[string]string{}
+	for k, v := range c.vars {
+		if env, ok := os.LookupEnv(k); ok {
+			v = env
+		}
+		vars[k] = v
+	}
+	root, err := filepath.Abs(filepath.Dir(c.file))
+	if err != nil {
+		root = filepath.Dir(c.file)
+	}
+	vars["root"] = root
+	vars["rule"] = ""
+	vars["file"] = "{file}"
+	return vars
+}
+
+var varPattern = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// interpolate replaces the known ${name} in a command and its
+// arguments. Others, like ${HOME}, are left to the shell.
+func interpolate(command string, argv []string, vars map[string]string) (string, []string) {
+	expand := func(s string) string {
+		return varPattern.ReplaceAllStringFunc(s, func(m string) string {
+			if v, ok := vars[m[2:len(m)-1]]; ok {
+				return v
+			}
+			return m
+		})
+	}
+	var out []string
+	for _, arg := range argv {
+		out = append(out, expand(arg))
+	}
+	return expand(command), out
+}
+
+// commandValue returns the command of a node, either a string or, for
+// --no-shell, a list of arguments.
+func commandValue(file string, node *yaml.Node) (string, []string, error) {
+	if node.Kind == yaml.ScalarNode {
+		return node.Value, strings.Fields(node.Value), nil
+	}
+	if node.Kind != yaml.SequenceNode {
+		return "", nil, errorAt(file, node, "command must be a string or a list of arguments")
+	}
+	argv, err := values(file, node)
+	if err != nil {
+		return "", nil, err
+	}
+	return strings.Join(argv, " "), argv, nil
+}
+
+// values returns the strings of a scalar, list or mapping node.
+func values(file string, node *yaml.Node) ([]string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return []string{node.Value}, nil
+	case yaml.SequenceNode:
+		var values []string
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, errorAt(file, item, "expected a string")
+			}
+			values = append(values, item.Value)
+		}
+		return values, nil
+	case yaml.MappingNode:
+		var values []string
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			k, v := node.Content[i], node.Content[i+1]
+			if v.Kind != yaml.ScalarNode {
+				return nil, errorAt(file, v, "expected a string")
+			}
+			values = append(values, k.Value+"="+v.Value)
+		}
+		return values, nil
+	}
+	return nil, errorAt(file, node, "expected a string, list or mapping")
+}
+
+func (c *config) errorf(node *yaml.Node, format string, args ...any) error {
+	return errorAt(c.file, node, format, args...)
+}
+
+// errorAt returns an error pointing at the line of node in file.
+func errorAt(file string, node *yaml.Node, format string, args ...any) error {
+	return fmt.Errorf("%s:%d: %s", file, node.Line, fmt.Sprintf(format, args...))
+}
+
+// applyConfig reads the config file into o. Flags already given on the
+// command line keep their value, then come the profile's and then the
+// top-level ones; each rule starts from the resulting options and adds
+// its own. Without a profile all rules run.
+func (o *options) applyConfig(fs *flag.FlagSet, file, profileName string) error {
+	c, err := loadConfig(file)
+	if err != nil {
+		return err
+	}
+	rules := c.rules
+	var settings []setting
+	if profileName != "" {
+		i := slices.IndexFunc(c.profiles, func(p profile) bool { return p.name == profileName })
+		if i < 0 {
+			var names []string
+			for _, p := range c.profiles {
+				names = append(names, p.name)
+			}
+			return fmt.Errorf("%s: no profile %q (have: %s)", file, profileName, strings.Join(names, ", "))
+		}
+		p := c.profiles[i]
+		rules = nil
+		for _, name := range p.rules {
+			rules = append(rules, *c.rule(name.Value))
+		}
+		settings = p.settings
+	}
+	settings = append(settings, c.settings...)
+
+	if len(o.paths) == 0 {
+		o.paths = slices.Clone(c.paths)
+	}
+	vars := c.variables()
+	if o.command == "" && o.commandFile == "" {
+		o.command, o.argv = interpolate(c.command, c.argv, vars)
+	}
+
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for _, s := range settings {
+		if given[s.key.Value] {
+			continue
+		}
+		given[s.key.Value] = true
+		if err := s.set(fs); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range rules {
+		vars["rule"] = r.name
+		r.command, r.argv = interpolate(r.command, r.argv, vars)
+		ro, err := r.options(o)
+		if err != nil {
+			return err
+		}
+
+		shown := ro.command
+		if shown == "" {
+			shown = ro.commandFile
+		}
+		o.rules = append(o.rules, ruleSpec{name: r.name, command: shown, argv: ro.argv, paths: r.paths, lock: r.lock, opts: ro})
+		for _, p := range r.paths {
+			if !slices.Contains(o.paths, p) {
+				o.paths = append(o.paths, p)
+			}
+		}
+	}
+	return nil
+}
+
+// options returns the options of the rule: base with its own flags.
+func (r configRule) options(base *options) (*options, error) {
+	ro := base.clone()
+	fs := flag.NewFlagSet(r.name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	ro.define(fs)
+	for _, s := range r.settings {
+		if err := s.set(fs); err != nil {
+			return nil, err
+		}
+	}
+	ro.command, ro.argv = r.command, r.argv
+	ro.paths = r.paths
+	if ro.prefix == "" {
+		// Tell apart the output of rules running side by side
+		ro.prefix = "[" + r.name + "] "
+	}
+	ro.rules = nil
+	if err := ro.validate(); err != nil {
+		return nil, errorAt(r.file, r.node, "rule %s: %v", r.name, err)
+	}
+	if ro.command == "" && ro.commandFile == "" {
+		return nil, errorAt(r.file, r.node, "rule %s: no command", r.name)
+	}
+	return ro, nil
+}
+
+// validateConfig checks a config file without running it: besides what
+// would stop on_change from starting, it reports malformed globs, paths
+// that do not exist and rules running the same command for the same
+// paths. Relative paths are looked up in dir, where on_change would run
+// with the file.
+func validateConfig(file, dir string) []error {
+	c, err := loadConfig(file)
+	if err != nil {
+		return []error{err}
+	}
+	var errs []error
+	o := newOptions()
+	fs := flag.NewFlagSet(file, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	o.define(fs)
+	check := func(settings []setting) {
+		for _, s := range settings {
+			if err := s.set(fs); err != nil {
+				errs = append(errs, err)
+			} else if s.key.Value == "exclude" {
+				errs = append(errs, checkGlobs(s.file, s.value)...)
+			}
+		}
+	}
+	check(c.settings)
+	for _, p := range c.profiles {
+		check(p.settings)
+	}
+	if err := o.validate(); err != nil {
+		errs = append(errs, fmt.Errorf("%s: %v", file, err))
+	}
+	if c.pathsNode != nil {
+		errs = append(errs, checkGlobs(c.pathsFile, c.pathsNode)...)
+		for _, p := range missingPaths(c.paths, dir) {
+			errs = append(errs, errorAt(c.pathsFile, c.pathsNode, "%s does not exist", p))
+		}
+	}
+
+	seen := map[string]configRule{}
+	for _, r := range c.rules {
+		if _, err := r.options(o); err != nil {
+			errs = append(errs, err)
+		}
+		for _, s := range r.settings {
+			if s.key.Value == "exclude" {
+				errs = append(errs, checkGlobs(s.file, s.value)...)
+			}
+		}
+		if r.pathsNode != nil {
+			errs = append(errs, checkGlobs(r.file, r.pathsNode)...)
+			for _, p := range missingPaths(r.paths, dir) {
+				errs = append(errs, errorAt(r.file, r.pathsNode, "rule %s: %s does not exist", r.name, p))
+			}
+		}
+
+		key := r.command + "\x00" + strings.Join(slices.Sorted(slices.Values(r.paths)), "\x00")
+		if prev, ok := seen[key]; ok && r.command != "" {
+			errs = append(errs, errorAt(r.file, r.node, "rule %s: same command and paths as rule %s", r.name, prev.name))
+		}
+		seen[key] = r
+	}
+	return errs
+}
+
+// missingPaths returns the paths whose fixed part, before any glob, does
+// not exist; relative ones are looked up in dir.
+func missingPaths(paths []string, dir string) []string {
+	var missing []string
+	for _, p := range paths {
+		if isURL(p) || p == "-" {
+			continue
+		}
+		prefix := staticPrefix(filepath.FromSlash(p))
+		if !filepath.IsAbs(prefix) {
+			prefix = filepath.Join(dir, prefix)
+		}
+		if _, err := os.Stat(prefix); err != nil {
+			missing = append(missing, p)
+		}
+	}
+	return missing
+}
+
+// checkGlobs reports the malformed patterns of a scalar or list node.
+func checkGlobs(file string, node *yaml.Node) []error {
+	patterns, err := values(file, node)
+	if err != nil {
+		return []error{err}
+	}
+	var errs []error
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			errs = append(errs, errorAt(file, node, "%s: %v", pattern, err))
+		}
+	}
+	return errs
+}
+
+// emitConfig writes the command line of o as a config file.
+func emitConfig(w io.Writer, o *options) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	add := func(key string, value any) error {
+		var node yaml.Node
+		if err := node.Encode(value); err != nil {
+			return err
+		}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, &node)
+		return nil
+	}
+	for _, f := range o.given {
+		if err := add(f.Name, configValue(f.Value)); err != nil {
+			return err
+		}
+	}
+	if len(o.paths) > 0 {
+		if err := add("paths", o.paths); err != nil {
+			return err
+		}
+	}
+	if o.noShell && len(o.argv) > 1 {
+		if err := add("command", o.argv); err != nil {
+			return err
+		}
+	} else if o.command != "" {
+		if err := add("command", o.command); err != nil {
+			return err
+		}
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(root); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// configValue returns a flag's value as it is written in a config file:
+// repeatable flags as lists, the others as their natural type.
+func configValue(v flag.Value) any {
+	switch v := v.(type) {
+	case *stringList:
+		return []string(*v)
+	case *regexList:
+		var list []string
+		for _, re := range *v {
+			list = append(list, re.String())
+		}
+		return list
+	case *ruleList:
+		var list []string
+		for _, r := range *v {
+			list = append(list, r.pattern+"="+r.command)
+		}
+		return list
+	case *durationRules:
+		if len(v.rules) == 0 {
+			return v.def.String()
+		}
+		return strings.Split(v.String(), ",")
+	case flag.Getter:
+		switch value := v.Get().(type) {
+		case bool, int, float64:
+			return value
+		}
+	}
+	return v.String()
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigs writes config files, given as name and content pairs,
+// into a temporary directory and returns the path of the first one.
+func writeConfigs(t *testing.T, files ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for i := 0; i+1 < len(files); i += 2 {
+		if err := os.WriteFile(filepath.Join(dir, files[i]), []byte(files[i+1]), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, files[0])
+}
+
+// parseConfig parses a command line using file as --config.
+func parseConfig(t *testing.T, file string, args ...string) (*options, error) {
+	t.Helper()
+	t.Setenv("ON_CHANGE_OPTS", "")
+	return parseArgs("on_change", append([]string{"--config", file}, args...), io.Discard)
+}
+
+const rulesConfig = `
+r: true
+exclude: [vendor/**]
+debounce: 300ms
+paths: [.]
+command: make
+rules:
+  build:
+    paths: ['**/*.go']
+    command: go build ./...
+    debounce: 1s
+  server:
+    paths: [cmd/, internal/]
+    command: [./server, --port, "8080"]
+    restart: true
+    env: {PORT: 8080}
+`
+
+func TestApplyConfig(t *testing.T) {
+	o, err := parseConfig(t, writeConfigs(t, "onchange.yml", rulesConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !o.recursive || !slices.Equal(o.excludes, []string{"vendor/**"}) || o.debounce.def != 300*time.Millisecond {
+		t.Errorf("top-level flags not applied: r %v, exclude %q, debounce %s", o.recursive, o.excludes, o.debounce.def)
+	}
+	if o.command != "make" {
+		t.Errorf("command = %q, want make", o.command)
+	}
+	if want := []string{".", "**/*.go", "cmd/", "internal/"}; !slices.Equal(o.paths, want) {
+		t.Errorf("paths = %q, want %q", o.paths, want)
+	}
+	if len(o.rules) != 2 {
+		t.Fatalf("%d rules, want 2", len(o.rules))
+	}
+
+	build, server := o.rules[0], o.rules[1]
+	if build.name != "build" || build.command != "go build ./..." || build.opts.debounce.def != time.Second || build.opts.restart {
+		t.Errorf("build = %s %q, debounce %s, restart %v", build.name, build.command, build.opts.debounce.def, build.opts.restart)
+	}
+	if !slices.Equal(build.opts.excludes, []string{"vendor/**"}) || build.opts.prefix != "[build] " {
+		t.Errorf("build: exclude %q, prefix %q", build.opts.excludes, build.opts.prefix)
+	}
+	if server.name != "server" || !slices.Equal(server.argv, []string{"./server", "--port", "8080"}) || !server.opts.restart {
+		t.Errorf("server = %s %q, restart %v", server.name, server.argv, server.opts.restart)
+	}
+	if server.opts.debounce.def != 300*time.Millisecond || !slices.Equal(server.opts.envs, []string{"PORT=8080"}) {
+		t.Errorf("server: debounce %s, env %q", server.opts.debounce.def, server.opts.envs)
+	}
+}
+
+func TestApplyConfigCommandLine(t *testing.T) {
+	// The command line wins over the file
+	o, err := parseConfig(t, writeConfigs(t, "onchange.yml", rulesConfig), "--debounce", "50ms", "src", "--", "true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.debounce.def != 50*time.Millisecond || o.command != "true" || o.paths[0] != "src" {
+		t.Errorf("debounce %s, command %q, paths %q", o.debounce.def, o.command, o.paths)
+	}
+	if d := o.rules[1].opts.debounce.def; d != 50*time.Millisecond {
+		t.Errorf("server debounce = %s, want the command line's 50ms", d)
+	}
+}
+
+func TestApplyConfigErrors(t *testing.T) {
+	tests := []struct {
+		config, want string
+	}{
+		{"bogus: true\npaths: [.]\ncommand: make\n", `onchange.yml:1: unknown flag "bogus"`},
+		{"debounce: soon\npaths: [.]\ncommand: make\n", "onchange.yml:1: debounce:"},
+		{"rules:\n  a:\n    paths: [.]\n    poll: true\n    command: make\n", "onchange.yml:4: rule a: poll can only be set at the top level"},
+		{"rules:\n  a:\n    paths: [.]\n", "onchange.yml:2: rule a: no command"},
+		{"rules: [a, b]\n", "onchange.yml:1: rules must map names to rules"},
+		{"- make\n", "onchange.yml:1: expected flags and rules"},
+		{"command: {a: b}\n", "onchange.yml:1: command must be a string or a list of arguments"},
+	}
+	for _, tt := range tests {
+		_, err := parseConfig(t, writeConfigs(t, "onchange.yml", tt.config))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: error %v, want %q", tt.config, err, tt.want)
+		}
+	}
+}
+
+func TestApplyConfigProfile(t *testing.T) {
+	file := writeConfigs(t, "onchange.yml", rulesConfig+`
+profiles:
+  build: [build]
+  dev:
+    rules: [server]
+    debounce: 2s
+`)
+	tests := []struct {
+		profile  string
+		rules    []string
+		debounce time.Duration
+	}{
+		{"", []string{"build", "server"}, 300 * time.Millisecond},
+		{"build", []string{"build"}, 300 * time.Millisecond},
+		// The profile's flags win over the top-level ones
+		{"dev", []string{"server"}, 2 * time.Second},
+	}
+	for _, tt := range tests {
+		o, err := parseConfig(t, file, "--profile", tt.profile)
+		if err != nil {
+			t.Errorf("--profile %q: %v", tt.profile, err)
+			continue
+		}
+		var names []string
+		for _, r := range o.rules {
+			names = append(names, r.name)
+		}
+		if !slices.Equal(names, tt.rules) || o.debounce.def != tt.debounce {
+			t.Errorf("--profile %q: rules %q, debounce %s, want %q, %s", tt.profile, names, o.debounce.def, tt.rules, tt.debounce)
+		}
+	}
+
+	if _, err := parseConfig(t, file, "--profile", "test"); err == nil || !strings.Contains(err.Error(), `no profile "test" (have: build, dev)`) {
+		t.Errorf("unknown profile: %v", err)
+	}
+	missing := writeConfigs(t, "onchange.yml", rulesConfig+"profiles:\n  test: [test]\n")
+	if _, err := parseConfig(t, missing); err == nil || !strings.Contains(err.Error(), "onchange.yml:18: profile test: no rule test") {
+		t.Errorf("profile of an unknown rule: %v", err)
+	}
+}
+
+func TestLoadConfigIncludes(t *testing.T) {
+	file := writeConfigs(t,
+		"onchange.yml", `
+include: [common.yml]
+debounce: 300ms
+rules:
+  build:
+    command: go build ./...
+`,
+		"common.yml", `
+r: true
+debounce: 1s
+exclude: [vendor/**]
+rules:
+  build:
+    paths: ['**/*.go']
+    command: make
+    throttle: 2s
+  lint:
+    paths: ['**/*.go']
+    command: golangci-lint run
+`,
+		"onchange.local.yml", `
+verbose: true
+rules:
+  lint:
+    command: go vet ./...
+`)
+	o, err := parseConfig(t, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Later files win, the overlay last
+	if !o.recursive || !o.verbose || o.debounce.def != 300*time.Millisecond || !slices.Equal(o.excludes, []string{"vendor/**"}) {
+		t.Errorf("r %v, verbose %v, debounce %s, exclude %q", o.recursive, o.verbose, o.debounce.def, o.excludes)
+	}
+	if len(o.rules) != 2 {
+		t.Fatalf("%d rules, want 2", len(o.rules))
+	}
+	// A rule defined again keeps what it does not set
+	build, lint := o.rules[0], o.rules[1]
+	if build.command != "go build ./..." || !slices.Equal(build.paths, []string{"**/*.go"}) || build.opts.throttle.def != 2*time.Second {
+		t.Errorf("build = %q for %q, throttle %s", build.command, build.paths, build.opts.throttle.def)
+	}
+	if lint.command != "go vet ./..." || !slices.Equal(lint.paths, []string{"**/*.go"}) {
+		t.Errorf("lint = %q for %q", lint.command, lint.paths)
+	}
+}
+
+func TestLoadConfigIncludeErrors(t *testing.T) {
+	cycle := writeConfigs(t, "a.yml", "include: b.yml\n", "b.yml", "include: [a.yml]\n")
+	if _, err := loadConfig(cycle); err == nil || !strings.Contains(err.Error(), "a.yml: included from itself") {
+		t.Errorf("include cycle: %v", err)
+	}
+	missing := writeConfigs(t, "a.yml", "r: true\ninclude: nope.yml\n")
+	if _, err := loadConfig(missing); err == nil || !strings.Contains(err.Error(), "a.yml:2: include: open") {
+		t.Errorf("missing include: %v", err)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	file := writeConfigs(t, "onchange.yml", `paths: [src, 'missing/**']
+exclude: ['[']
+command: make
+rules:
+  a:
+    paths: [src]
+    command: make test
+  b:
+    paths: [src]
+    command: make test
+  c:
+    paths: ['gone/*.go']
+    command: make
+    jobs: 0
+`)
+	dir := filepath.Dir(file)
+	if err := os.Mkdir(filepath.Join(dir, "src"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"onchange.yml:2: [: syntax error in pattern",
+		"onchange.yml:1: missing/** does not exist",
+		"onchange.yml:8: rule b: same command and paths as rule a",
+		"onchange.yml:11: rule c: --jobs must be at least 1",
+		"onchange.yml:12: rule c: gone/*.go does not exist",
+	}
+	check := func(dir string, want []string) {
+		t.Helper()
+		var got []string
+		for _, err := range validateConfig(file, dir) {
+			got = append(got, strings.TrimPrefix(err.Error(), filepath.Dir(file)+string(filepath.Separator)))
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("validateConfig(%s) =\n%s\nwant\n%s", dir, strings.Join(got, "\n"), strings.Join(want, "\n"))
+		}
+	}
+	check(dir, want)
+	// Relative paths are where on_change runs
+	check(t.TempDir(), []string{
+		"onchange.yml:2: [: syntax error in pattern",
+		"onchange.yml:1: src does not exist",
+		"onchange.yml:1: missing/** does not exist",
+		"onchange.yml:6: rule a: src does not exist",
+		"onchange.yml:9: rule b: src does not exist",
+		"onchange.yml:8: rule b: same command and paths as rule a",
+		"onchange.yml:11: rule c: --jobs must be at least 1",
+		"onchange.yml:12: rule c: gone/*.go does not exist",
+	})
+
+	if errs := validateConfig(writeConfigs(t, "ok.yml", "paths: [.]\ncommand: make\n"), ""); len(errs) != 0 {
+		t.Errorf("valid config: %v", errs)
+	}
+}
+
+func TestEmitConfig(t *testing.T) {
+	t.Setenv("ON_CHANGE_OPTS", "--hash --exclude 'node_modules/**'")
+	args := []string{"-r", "--exclude", "vendor/**", "--exclude", "*.tmp", "--debounce", "300ms", "--rule", "*.proto=make proto", "src", "--", "go", "run", ".", "--emit-config"}
+	o, err := parseArgs("on_change", args, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !o.emitConfig {
+		t.Fatal("--emit-config at the end of the command was not recognized")
+	}
+	var b strings.Builder
+	if err := emitConfig(&b, o); err != nil {
+		t.Fatal(err)
+	}
+	// Without the ON_CHANGE_OPTS defaults
+	want := `debounce: 300ms
+exclude:
+  - vendor/**
+  - '*.tmp'
+r: true
+rule:
+  - '*.proto=make proto'
+paths:
+  - src
+command: go run .
+`
+	if b.String() != want {
+		t.Errorf("emitConfig() =\n%s\nwant\n%s", b.String(), want)
+	}
+
+	// Running the generated file is running the command line
+	file := writeConfigs(t, "onchange.yml", b.String())
+	t.Setenv("ON_CHANGE_OPTS", "")
+	c, err := parseArgs("on_change", []string{"--config", file}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.recursive || c.hash || !slices.Equal(c.excludes, []string{"vendor/**", "*.tmp"}) || c.debounce.def != 300*time.Millisecond ||
+		len(c.rules) != 1 || !slices.Equal(c.paths, []string{"src"}) || c.command != "go run ." {
+		t.Errorf("from the generated file: r %v, hash %v, exclude %q, debounce %s, %d rule(s), paths %q, command %q",
+			c.recursive, c.hash, c.excludes, c.debounce.def, len(c.rules), c.paths, c.command)
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	vars := map[string]string{"out": "build/bin", "rule": "api", "file": "{file}"}
+	command, argv := interpolate("go build -o ${out}/${rule} ${file} ${HOME} $out",
+		[]string{"go", "build", "-o", "${out}/${rule}", "${nope}"}, vars)
+	if want := "go build -o build/bin/api {file} ${HOME} $out"; command != want {
+		t.Errorf("command = %q, want %q", command, want)
+	}
+	if want := []string{"go", "build", "-o", "build/bin/api", "${nope}"}; !slices.Equal(argv, want) {
+		t.Errorf("argv = %q, want %q", argv, want)
+	}
+}
+
+func TestApplyConfigVars(t *testing.T) {
+	file := writeConfigs(t, "onchange.yml", `
+vars: {out: build, target: linux}
+paths: [.]
+command: echo top ${rule} ${out}
+rules:
+  api:
+    paths: [api/]
+    command: go build -o ${out}/${rule}-${target} ${root} ${file}
+`)
+	t.Setenv("target", "darwin")
+	o, err := parseConfig(t, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "echo top  build"; o.command != want {
+		t.Errorf("top-level command = %q, want %q", o.command, want)
+	}
+	want := "go build -o build/api-darwin " + filepath.Dir(file) + " {file}"
+	if got := o.rules[0].command; got != want {
+		t.Errorf("rule command = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// controlServer accepts commands for a running instance on a Unix
+// domain socket, one per line:
+//
+//	add PATH     start watching PATH (a file, directory or pattern)
+//	remove PATH  stop watching PATH
+//	list         print the watched paths
+//	status       print whether watching is paused and the last run of
+//	             every rule
+//	trigger      run every rule's command now
+//	pause        stop running the command on changes, keeping the watches
+//	resume       run on changes again
+//
+// Every command is answered with "ok" or "error: <reason>".
+type controlServer struct {
+	path   string
+	ln     net.Listener
+	ws     *watchSet
+	rules  *ruleSet
+	pauses chan<- bool  // to the main loop, true pauses and false resumes
+	paused *atomic.Bool // kept up to date by the main loop
+}
+
+func startControl(path string, ws *watchSet, rules *ruleSet, pauses chan<- bool, paused *atomic.Bool) (*controlServer, error) {
+	// a socket left behind by an instance that did not shut down cleanly
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s is in use by another instance", path)
+	}
+	os.Remove(path)
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	c := &controlServer{path: path, ln: ln, ws: ws, rules: rules, pauses: pauses, paused: paused}
+	go c.serve()
+	return c, nil
+}
+
+func (c *controlServer) Close() error {
+	err := c.ln.Close()
+	os.Remove(c.path)
+	return err
+}
+
+func (c *controlServer) serve() {
+	for {
+		conn, err := c.ln.Accept()
+		if err != nil {
+			return
+		}
+		go c.handle(conn)
+	}
+}
+
+func (c *controlServer) handle(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+
+		var err error
+		switch verb {
+		case "add":
+			if arg == "" {
+				err = fmt.Errorf("usage: add PATH")
+			} else if err = c.ws.add(arg); err == nil {
+				logf("Now watching %s\n", arg)
+			}
+		case "remove", "rm":
+			if arg == "" {
+				err = fmt.Errorf("usage: remove PATH")
+			} else if err = c.ws.remove(arg); err == nil {
+				logf("No longer watching %s\n", arg)
+			}
+		case "status":
+			c.status(conn)
+		case "trigger":
+			logf("Run requested over the control socket\n")
+			c.rules.force()
+		case "pause":
+			c.pauses <- true
+		case "resume":
+			c.pauses <- false
+		case "list":
+			for _, p := range c.ws.list() {
+				fmt.Fprintln(conn, p)
+			}
+		default:
+			err = fmt.Errorf("unknown command %q", verb)
+		}
+
+		if err != nil {
+			fmt.Fprintf(conn, "error: %v\n", err)
+		} else {
+			fmt.Fprintln(conn, "ok")
+		}
+	}
+}
+
+func (c *controlServer) status(conn net.Conn) {
+	registered, _ := c.ws.watchCount()
+	fmt.Fprintf(conn, "pid %d\n", os.Getpid())
+	fmt.Fprintf(conn, "paused %t\n", c.paused.Load())
+	fmt.Fprintf(conn, "watches %d\n", registered)
+	for _, r := range c.rules.rules {
+		running, lastEnd, lastCode := r.run.state()
+		stats := r.run.timings()
+		line := fmt.Sprintf("rule %q: %d run(s), %d failed", r.command, stats.n, stats.failed)
+		if r.name != "" {
+			line = fmt.Sprintf("rule %s (%s): %d run(s), %d failed", r.name, r.command, stats.n, stats.failed)
+		}
+		if !lastEnd.IsZero() {
+			line += fmt.Sprintf(", last exit %d at %s", lastCode, lastEnd.Format(time.RFC3339))
+		}
+		if running {
+			line += ", running"
+		}
+		fmt.Fprintln(conn, line)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// runtimeDir holds a control socket named PID.sock for every running
+// instance, for "on_change ctl" to find.
+func runtimeDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "on_change")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("on_change-%d", os.Getuid()))
+}
+
+// runtimeSocket returns the control socket of this instance in
+// runtimeDir, creating the directory.
+func runtimeSocket() (string, error) {
+	dir := runtimeDir()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	if err := checkRuntimeDir(dir); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, strconv.Itoa(os.Getpid())+".sock"), nil
+}
+
+// checkRuntimeDir refuses a runtimeDir that others could have created
+// in a shared temporary directory to plant sockets or connect to ours:
+// it must be a real directory of ours that nobody else can enter.
+func checkRuntimeDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if !private(info) {
+		return fmt.Errorf("%s must belong to us and be closed to others (mode 0700)", dir)
+	}
+	return nil
+}
+
+// ctl runs "on_change ctl [-s SOCKET] [-pid PID] COMMAND [PATH]" and
+// returns its exit status.
+func ctl(args []string) int {
+	fs := flag.NewFlagSet("ctl", flag.ContinueOnError)
+	socket := fs.String("s", "", "control socket of the instance, as given to --control")
+	pid := fs.Int("pid", 0, "process ID of the instance, if several are running")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s ctl [-s SOCKET] [-pid PID] status|trigger|pause|resume|list|add PATH|rm PATH\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	command := strings.Join(fs.Args(), " ")
+
+	path := *socket
+	if path == "" {
+		found, err := findInstance(*pid)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		path = found
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer conn.Close()
+	fmt.Fprintln(conn, command)
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "ok" {
+			return 0
+		}
+		if msg, ok := strings.CutPrefix(line, "error: "); ok {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", msg)
+			return 1
+		}
+		fmt.Println(line)
+	}
+	fmt.Fprintf(os.Stderr, "Error: no answer from %s\n", path)
+	return 1
+}
+
+// findInstance returns the control socket of the running instance with
+// the given pid, or of the only one running for pid 0. Sockets left
+// behind by instances that are gone are removed.
+func findInstance(pid int) (string, error) {
+	dir := runtimeDir()
+	if err := checkRuntimeDir(dir); err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("no running instance found in %s", dir)
+		}
+		return "", err
+	}
+	if pid != 0 {
+		return filepath.Join(dir, strconv.Itoa(pid)+".sock"), nil
+	}
+	sockets, _ := filepath.Glob(filepath.Join(dir, "*.sock"))
+	var live []string
+	for _, s := range sockets {
+		conn, err := net.Dial("unix", s)
+		if err != nil {
+			os.Remove(s)
+			continue
+		}
+		conn.Close()
+		live = append(live, s)
+	}
+	switch len(live) {
+	case 0:
+		return "", fmt.Errorf("no running instance found in %s", dir)
+	case 1:
+		return live[0], nil
+	}
+	var pids []string
+	for _, s := range live {
+		pids = append(pids, strings.TrimSuffix(filepath.Base(s), ".sock"))
+	}
+	return "", fmt.Errorf("%d instances are running, choose one with -pid: %s", len(live), strings.Join(pids, ", "))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// daemonEnv marks the background process started by --daemon; it holds
+// the descriptor on which the process reports that it is watching.
+const daemonEnv = "ON_CHANGE_DAEMON"
+
+// daemonized reports whether this is the background process of --daemon.
+func daemonized() bool {
+	return os.Getenv(daemonEnv) != ""
+}
+
+// daemonReady moves stdout and stderr of the background process to the
+// --log-file, or /dev/null without one, and lets the process that
+// started it exit. Messages then only go to the log file, with their
+// timestamps, instead of also being printed into it.
+func daemonReady() error {
+	fd, err := strconv.Atoi(os.Getenv(daemonEnv))
+	os.Unsetenv(daemonEnv)
+	if err != nil {
+		return fmt.Errorf("bad %s", daemonEnv)
+	}
+	if logFile != nil {
+		if err := logFile.redirectStdio(); err != nil {
+			return err
+		}
+		logMu.Lock()
+		quiet = true
+		logMu.Unlock()
+	} else {
+		null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		defer null.Close()
+		if err := redirectStdio(null); err != nil {
+			return err
+		}
+	}
+	ready := os.NewFile(uintptr(fd), "ready")
+	defer ready.Close()
+	_, err = ready.Write([]byte{1})
+	return err
+}
+
+// checkPidfile fails if the pidfile names a process that still runs.
+func checkPidfile(path string) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err == nil && pid != os.Getpid() && processAlive(pid) {
+		return fmt.Errorf("%s: already running as pid %d", path, pid)
+	}
+	return nil
+}
+
+// writePidfile records our pid in path and returns a function removing
+// it again.
+func writePidfile(path string) (func(), error) {
+	if err := checkPidfile(path); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+		return nil, err
+	}
+	return func() { os.Remove(path) }, nil
+}
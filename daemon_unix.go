@@ -0,0 +1,87 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// daemonize starts on_change again with the same arguments, in a session
+// of its own and without a terminal, for --daemon. It waits until that
+// process watches, so errors in the setup are still shown here, and
+// returns the exit status for the foreground process.
+func daemonize() int {
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --daemon: %v\n", err)
+		return 1
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --daemon: %v\n", err)
+		return 1
+	}
+	null, err := os.Open(os.DevNull)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --daemon: %v\n", err)
+		return 1
+	}
+	defer null.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = null, os.Stdout, os.Stderr
+	cmd.ExtraFiles = []*os.File{w}
+	cmd.Env = append(os.Environ(), daemonEnv+"=3")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		r.Close()
+		fmt.Fprintf(os.Stderr, "Error: --daemon: %v\n", err)
+		return 1
+	}
+
+	// One byte once it watches, nothing if it exits before
+	var b [1]byte
+	n, _ := r.Read(b[:])
+	r.Close()
+	if n == 0 {
+		cmd.Wait()
+		if code := cmd.ProcessState.ExitCode(); code > 0 {
+			return code
+		}
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Running in the background as pid %d\n", cmd.Process.Pid)
+	return 0
+}
+
+// redirectStdio points stdout and stderr at f.
+func redirectStdio(f *os.File) error {
+	for _, fd := range []int{1, 2} {
+		if err := unix.Dup2(int(f.Fd()), fd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processAlive reports whether a process with pid exists.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// hangups returns a channel receiving SIGHUP, on which --daemon reopens
+// its log file.
+func hangups() <-chan os.Signal {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	return c
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// daemonize fails, --daemon is not supported on Windows; use a service
+// wrapper instead.
+func daemonize() int {
+	fmt.Fprintln(os.Stderr, "Error: --daemon is not supported on Windows")
+	return 1
+}
+
+func redirectStdio(f *os.File) error {
+	return errors.New("not supported on Windows")
+}
+
+// processAlive reports whether a process with pid exists.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
+
+// hangups returns nil, Windows has no SIGHUP.
+func hangups() <-chan os.Signal { return nil }
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"--debounce 300ms --hash", []string{"--debounce", "300ms", "--hash"}},
+		{"a\tb\nc", []string{"a", "b", "c"}},
+		{`--prefix '[api] '`, []string{"--prefix", "[api] "}},
+		{`--prefix "[a b]"`, []string{"--prefix", "[a b]"}},
+		{`a\ b`, []string{"a b"}},
+		{`"a \"quoted\" word"`, []string{`a "quoted" word`}},
+		{`'no \escapes'`, []string{`no \escapes`}},
+		{`''`, []string{""}},
+		{`x""y`, []string{"xy"}},
+		{`it's" "ok'`, []string{`its" "ok`}},
+	}
+	for _, tt := range tests {
+		got, err := splitWords(tt.in)
+		if err != nil {
+			t.Errorf("splitWords(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitWords(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSplitWordsErrors(t *testing.T) {
+	for _, in := range []string{`'open`, `"open`, `trailing\`} {
+		if got, err := splitWords(in); err == nil {
+			t.Errorf("splitWords(%q) = %q, want an error", in, got)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// eventStream sends every event and the command's output as JSON to
+// the clients of --ws and --grpc. It is nil without them.
+var eventStream *eventHub
+
+// eventHub fans records out to its subscribers. A subscriber that
+// cannot keep up misses records rather than slowing down on_change.
+type eventHub struct {
+	srv *http.Server // nil without --ws
+
+	mu          sync.Mutex
+	subscribers map[chan []byte]bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subscribers: map[chan []byte]bool{}}
+}
+
+// subscribe returns a channel receiving the JSON records from now on,
+// and a function ending the subscription.
+func (h *eventHub) subscribe() (<-chan []byte, func()) {
+	records := make(chan []byte, 256)
+	h.mu.Lock()
+	h.subscribers[records] = true
+	h.mu.Unlock()
+	return records, func() {
+		h.mu.Lock()
+		delete(h.subscribers, records)
+		h.mu.Unlock()
+	}
+}
+
+// listen serves the records to WebSocket clients on addr, for --ws,
+// from pages of the allowed origins only.
+func (h *eventHub) listen(addr string, origins []string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	h.srv = &http.Server{Handler: checkOrigin(http.HandlerFunc(h.serveWebSocket), origins)}
+	go h.srv.Serve(ln)
+	return nil
+}
+
+func (h *eventHub) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	c, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	records, cancel := h.subscribe()
+	defer cancel()
+
+	// Reading notices when the client goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case b := <-records:
+			if c.WriteText(b) != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// publish sends a record of the given kind with f to every client.
+func (h *eventHub) publish(kind string, f fields) {
+	if h == nil {
+		return
+	}
+	rec := fields{"event": kind, "time": time.Now().Format(time.RFC3339Nano)}
+	for k, v := range f {
+		rec[k] = v
+	}
+	if msg, ok := rec["message"].(string); ok {
+		rec["message"] = ansiCodes.ReplaceAllString(msg, "")
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for records := range h.subscribers {
+		select {
+		case records <- b:
+		default:
+		}
+	}
+}
+
+func (h *eventHub) Close() error {
+	if h.srv == nil {
+		return nil
+	}
+	return h.srv.Close()
+}
+
+// outputStream publishes what a command writes to one of its streams.
+type outputStream struct {
+	stream  string
+	command string
+}
+
+func (o outputStream) Write(b []byte) (int, error) {
+	eventStream.publish("output", fields{"stream": o.stream, "command": o.command, "data": string(b)})
+	return len(b), nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// defaultIgnores are the temporary, swap and lock files common editors
+// leave next to the files being edited.
+var defaultIgnores = []string{
+	// vim
+	"*.swp", "*.swo", "*.swx", "*.swpx", "*~", "4913",
+	// emacs
+	".#*", "#*#",
+	// JetBrains safe write
+	"*___jb_tmp___", "*___jb_old___",
+	// kate, gedit
+	".*.kate-swp", ".goutputstream-*",
+	// OS metadata
+	".DS_Store", "Thumbs.db",
+}
+
+// pathFilter decides which paths are excluded by the user's filtering
+// flags, independently of what is being watched.
+type pathFilter struct {
+	cwd      string
+	excludes []string
+	exts     map[string]bool // nil means any extension
+	includes []*regexp.Regexp
+	ignores  []*regexp.Regexp
+}
+
+func newPathFilter(opts *options) *pathFilter {
+	cwd, _ := os.Getwd()
+	f := &pathFilter{
+		cwd:      cwd,
+		includes: opts.filters,
+		ignores:  opts.ignoreRegexes,
+	}
+	patterns := append([]string(nil), opts.excludes...)
+	if !opts.noDefaultIgnores {
+		patterns = append(patterns, defaultIgnores...)
+	}
+	for _, pattern := range patterns {
+		f.excludes = append(f.excludes, filepath.ToSlash(filepath.Clean(pattern)))
+	}
+	for _, list := range opts.exts {
+		for _, ext := range strings.Split(list, ",") {
+			ext = strings.TrimPrefix(strings.TrimSpace(ext), ".")
+			if ext == "" {
+				continue
+			}
+			if f.exts == nil {
+				f.exts = map[string]bool{}
+			}
+			f.exts[ext] = true
+		}
+	}
+	return f
+}
+
+// accepts reports whether a change to name may trigger the command.
+func (f *pathFilter) accepts(name string) bool {
+	return f.rejects(name) == ""
+}
+
+// rejects returns why a change to name may not trigger the command, or
+// "" if it may.
+func (f *pathFilter) rejects(name string) string {
+	if f.excluded(name) {
+		return "excluded"
+	}
+	if f.exts != nil && !f.exts[strings.TrimPrefix(filepath.Ext(name), ".")] {
+		return "extension not in --ext"
+	}
+	if len(f.includes) == 0 && len(f.ignores) == 0 {
+		return ""
+	}
+
+	subject, ok := f.rel(name)
+	if !ok {
+		subject = filepath.ToSlash(name)
+	}
+	for _, re := range f.ignores {
+		if re.MatchString(subject) {
+			return "matches --ignore-regex " + re.String()
+		}
+	}
+	if len(f.includes) == 0 {
+		return ""
+	}
+	for _, re := range f.includes {
+		if re.MatchString(subject) {
+			return ""
+		}
+	}
+	return "does not match --filter"
+}
+
+// excluded reports whether name matches one of the --exclude patterns.
+func (f *pathFilter) excluded(name string) bool {
+	for _, pattern := range f.excludes {
+		if f.matches(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether name matches a user supplied pattern. Patterns
+// without a slash match the base name at any depth, the others match the
+// path relative to the working directory and may use "**".
+func (f *pathFilter) matches(pattern, name string) bool {
+	pattern = filepath.ToSlash(pattern)
+	if !strings.Contains(pattern, "/") {
+		ok, _ := filepath.Match(pattern, filepath.Base(name))
+		return ok
+	}
+	if matchDoublestar(pattern, filepath.ToSlash(name)) {
+		return true
+	}
+	rel, ok := f.rel(name)
+	return ok && matchDoublestar(pattern, rel)
+}
+
+// rel returns name relative to the working directory, if it is below it.
+func (f *pathFilter) rel(name string) (string, bool) {
+	if f.cwd == "" {
+		return "", false
+	}
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(f.cwd, abs)
+	if err != nil || !within(".", rel) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ignoreRule is a single line from a .gitignore style file.
+type ignoreRule struct {
+	base     string // absolute directory the pattern is relative to
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+func (r ignoreRule) matches(abs string, dir bool) bool {
+	if r.dirOnly && !dir {
+		return false
+	}
+	rel, err := filepath.Rel(r.base, abs)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return false
+	}
+	if r.anchored {
+		return matchDoublestar(r.pattern, rel)
+	}
+	return matchDoublestar(r.pattern, path.Base(rel))
+}
+
+// gitIgnore answers whether a path is ignored by git, using the
+// .gitignore files of every directory from the repository top down and
+// .git/info/exclude. Rules are loaded lazily as directories are walked.
+type gitIgnore struct {
+	mu      sync.Mutex
+	exclude []ignoreRule
+	perDir  map[string][]ignoreRule
+}
+
+func newGitIgnore() *gitIgnore {
+	return &gitIgnore{
+		perDir: map[string][]ignoreRule{},
+	}
+}
+
+// addRoot loads the ignore files that apply to root from the directories
+// above it, up to the top of the enclosing git repository.
+func (g *gitIgnore) addRoot(root string) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return
+	}
+
+	var dirs []string
+	top := ""
+	for dir := abs; ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			top = dir
+			break
+		}
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	if top == "" {
+		g.loadDir(abs)
+		return
+	}
+
+	if isDir(filepath.Join(top, ".git")) {
+		rules := readIgnoreFile(filepath.Join(top, ".git", "info", "exclude"), top)
+		g.mu.Lock()
+		g.exclude = rules
+		g.mu.Unlock()
+	}
+	for _, dir := range dirs {
+		g.loadDir(dir)
+	}
+}
+
+// loadDir reads (or re-reads) the .gitignore in dir.
+func (g *gitIgnore) loadDir(dir string) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return
+	}
+	rules := readIgnoreFile(filepath.Join(abs, ".gitignore"), abs)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(rules) == 0 {
+		delete(g.perDir, abs)
+		return
+	}
+	g.perDir[abs] = rules
+}
+
+// ignored reports whether path, or any directory above it, is ignored.
+func (g *gitIgnore) ignored(name string, dir bool) bool {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return false
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.match(abs, dir) {
+		return true
+	}
+	for p := filepath.Dir(abs); p != filepath.Dir(p); p = filepath.Dir(p) {
+		if g.match(p, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// match applies the rules from the least to the most specific source;
+// as in git the last matching rule decides.
+func (g *gitIgnore) match(abs string, dir bool) bool {
+	if filepath.Base(abs) == ".git" {
+		return true
+	}
+
+	var ancestors []string
+	for p := filepath.Dir(abs); ; p = filepath.Dir(p) {
+		ancestors = append(ancestors, p)
+		if p == filepath.Dir(p) {
+			break
+		}
+	}
+
+	ignored := false
+	apply := func(rules []ignoreRule) {
+		for _, r := range rules {
+			if r.matches(abs, dir) {
+				ignored = !r.negate
+			}
+		}
+	}
+	apply(g.exclude)
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		apply(g.perDir[ancestors[i]])
+	}
+	return ignored
+}
+
+func readIgnoreFile(file, base string) []ignoreRule {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		r := ignoreRule{base: base}
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			line = line[1:] // escaped "#" or "!"
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			r.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		r.pattern = line
+		rules = append(rules, r)
+	}
+	return rules
+}
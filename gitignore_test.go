@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadIgnoreFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, ".gitignore")
+	content := "# comment\n\n*.o\n!keep.o\nbuild/\n/root.txt\ndocs/*.html\n\\#hash\n\\!bang\ntrailing   \n/\n"
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want := []ignoreRule{
+		{base: dir, pattern: "*.o"},
+		{base: dir, pattern: "keep.o", negate: true},
+		{base: dir, pattern: "build", dirOnly: true},
+		{base: dir, pattern: "root.txt", anchored: true},
+		{base: dir, pattern: "docs/*.html", anchored: true},
+		{base: dir, pattern: "#hash"},
+		{base: dir, pattern: "!bang"},
+		{base: dir, pattern: "trailing"},
+	}
+	if got := readIgnoreFile(file, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("readIgnoreFile:\n got %+v\nwant %+v", got, want)
+	}
+	if got := readIgnoreFile(filepath.Join(dir, "missing"), dir); got != nil {
+		t.Errorf("readIgnoreFile of a missing file = %+v, want nil", got)
+	}
+}
+
+func TestIgnoreRuleMatches(t *testing.T) {
+	base := filepath.FromSlash("/repo")
+	abs := func(rel string) string { return filepath.Join(base, filepath.FromSlash(rel)) }
+	tests := []struct {
+		rule ignoreRule
+		name string
+		dir  bool
+		want bool
+	}{
+		{ignoreRule{pattern: "*.o"}, "a.o", false, true},
+		{ignoreRule{pattern: "*.o"}, "src/deep/a.o", false, true},
+		{ignoreRule{pattern: "*.o"}, "a.c", false, false},
+		{ignoreRule{pattern: "build", dirOnly: true}, "build", true, true},
+		{ignoreRule{pattern: "build", dirOnly: true}, "build", false, false},
+		{ignoreRule{pattern: "build", dirOnly: true}, "src/build", true, true},
+		{ignoreRule{pattern: "root.txt", anchored: true}, "root.txt", false, true},
+		{ignoreRule{pattern: "root.txt", anchored: true}, "sub/root.txt", false, false},
+		{ignoreRule{pattern: "docs/*.html", anchored: true}, "docs/a.html", false, true},
+		{ignoreRule{pattern: "docs/*.html", anchored: true}, "docs/sub/a.html", false, false},
+		{ignoreRule{pattern: "**/gen", anchored: true}, "a/b/gen", true, true},
+	}
+	for _, tt := range tests {
+		tt.rule.base = base
+		if got := tt.rule.matches(abs(tt.name), tt.dir); got != tt.want {
+			t.Errorf("%+v matches(%q, dir=%v) = %v, want %v", tt.rule, tt.name, tt.dir, got, tt.want)
+		}
+	}
+	outside := ignoreRule{base: base, pattern: "*"}
+	if outside.matches(filepath.FromSlash("/other/a.o"), false) {
+		t.Error("a rule matched a path outside of its directory")
+	}
+}
+
+func TestGitIgnore(t *testing.T) {
+	top := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(top, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".git/info/exclude", "*.swp\n")
+	write(".gitignore", "*.log\nbuild/\n")
+	write("sub/.gitignore", "!keep.log\nlocal.txt\n")
+
+	g := newGitIgnore()
+	g.addRoot(top)
+	g.loadDir(filepath.Join(top, "sub"))
+	tests := []struct {
+		name string
+		dir  bool
+		want bool
+	}{
+		{"a.go", false, false},
+		{"a.log", false, true},
+		{"a.swp", false, true},
+		{"build", true, true},
+		{"build/out/x.bin", false, true},
+		{"sub/keep.log", false, false},
+		{"sub/other.log", false, true},
+		{"sub/local.txt", false, true},
+		{"local.txt", false, false},
+		{".git", true, true},
+		{".git/config", false, true},
+	}
+	for _, tt := range tests {
+		if got := g.ignored(filepath.Join(top, filepath.FromSlash(tt.name)), tt.dir); got != tt.want {
+			t.Errorf("ignored(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
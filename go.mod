@@ -2,6 +2,18 @@ module github.com/jackdoe/on_change
 
 go 1.23.1
 
-require github.com/fsnotify/fsnotify v1.9.0
+require (
+	github.com/creack/pty v1.1.24
+	github.com/fsnotify/fsnotify v1.9.0
+	golang.org/x/sys v0.26.0
+	golang.org/x/term v0.25.0
+	google.golang.org/grpc v1.67.1
+	google.golang.org/protobuf v1.34.2
+	gopkg.in/yaml.v3 v3.0.1
+)
 
-require golang.org/x/sys v0.13.0 // indirect
+require (
+	golang.org/x/net v0.28.0 // indirect
+	golang.org/x/text v0.17.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240814211410-ddb44dafa142 // indirect
+)
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// grpcServer implements the OnChange service of onchange.proto, for
+// --grpc. The service is described by hand, as it only uses the
+// well-known types.
+type grpcServer struct {
+	ws     *watchSet
+	rules  *ruleSet
+	pauses chan<- bool // to the main loop, true pauses and false resumes
+}
+
+const onChangeServiceName = "onchange.v1.OnChange"
+
+var onChangeService = grpc.ServiceDesc{
+	ServiceName: onChangeServiceName,
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{
+		unary("Watch", func(s *grpcServer, path *wrapperspb.StringValue) error {
+			if path.GetValue() == "" {
+				return status.Error(codes.InvalidArgument, "empty path")
+			}
+			if err := s.ws.add(path.GetValue()); err != nil {
+				return status.Error(codes.InvalidArgument, err.Error())
+			}
+			logf("Now watching %s\n", path.GetValue())
+			return nil
+		}),
+		unary("Trigger", func(s *grpcServer, _ *emptypb.Empty) error {
+			logf("Run requested over gRPC\n")
+			s.rules.force()
+			return nil
+		}),
+		unary("Pause", func(s *grpcServer, pause *wrapperspb.BoolValue) error {
+			s.pauses <- pause.GetValue()
+			return nil
+		}),
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "StreamEvents", Handler: streamRecords(false), ServerStreams: true},
+		{StreamName: "StreamOutput", Handler: streamRecords(true), ServerStreams: true},
+	},
+	Metadata: "onchange.proto",
+}
+
+func startGRPC(addr string, ws *watchSet, rules *ruleSet, pauses chan<- bool) (*grpc.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := grpc.NewServer()
+	srv.RegisterService(&onChangeService, &grpcServer{ws: ws, rules: rules, pauses: pauses})
+	go srv.Serve(ln)
+	return srv, nil
+}
+
+// unary describes a method taking a Req and returning Empty.
+func unary[Req any](name string, handle func(*grpcServer, *Req) error) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			req := new(Req)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			call := func(ctx context.Context, req any) (any, error) {
+				return &emptypb.Empty{}, handle(srv.(*grpcServer), req.(*Req))
+			}
+			if interceptor == nil {
+				return call(ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + onChangeServiceName + "/" + name}
+			return interceptor(ctx, req, info, call)
+		},
+	}
+}
+
+// streamRecords sends the records of eventStream until the client goes
+// away: the command's output, or everything else.
+func streamRecords(output bool) grpc.StreamHandler {
+	return func(srv any, stream grpc.ServerStream) error {
+		if err := stream.RecvMsg(&emptypb.Empty{}); err != nil {
+			return err
+		}
+		records, cancel := eventStream.subscribe()
+		defer cancel()
+		for {
+			select {
+			case <-stream.Context().Done():
+				return nil
+			case b := <-records:
+				var rec map[string]any
+				if json.Unmarshal(b, &rec) != nil || (rec["event"] == "output") != output {
+					continue
+				}
+				msg, err := structpb.NewStruct(rec)
+				if err != nil {
+					continue
+				}
+				if err := stream.SendMsg(msg); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
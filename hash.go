@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/sha256"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// contentHashes remembers a checksum per file so that rewrites with
+// identical content (touch, save without changes, regenerated files) do
+// not trigger the command.
+type contentHashes struct {
+	sums map[string][sha256.Size]byte
+}
+
+func newContentHashes() *contentHashes {
+	return &contentHashes{sums: map[string][sha256.Size]byte{}}
+}
+
+// seed records the current content of every file covered by ws.
+func (h *contentHashes) seed(ws *watchSet) {
+	ws.walkFiles(func(path string) {
+		h.update(path)
+	})
+}
+
+// changed re-hashes files and reports whether any of them differs from
+// the content recorded at the previous check. Directories and files that
+// cannot be read always count as changed.
+func (h *contentHashes) changed(files []string) bool {
+	found := false
+	for _, f := range files {
+		if h.update(f) {
+			found = true
+		}
+	}
+	return found
+}
+
+func (h *contentHashes) update(file string) bool {
+	old, known := h.sums[file]
+	sum, err := hashFile(file)
+	if err != nil {
+		delete(h.sums, file)
+		return true
+	}
+	h.sums[file] = sum
+	return !known || sum != old
+}
+
+func hashFile(file string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	f, err := os.Open(file)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return sum, err
+	}
+	if info.IsDir() {
+		return sum, fs.ErrInvalid
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return sum, err
+	}
+	copy(sum[:], hash.Sum(nil))
+	return sum, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// apiServer is the HTTP API of --http, for editors and dashboards:
+//
+//	GET  /status  the watched paths, whether watching is paused and the
+//	              last run of every rule, as JSON
+//	POST /run     run every rule's command now
+//	POST /pause   stop running on changes, keeping the watches
+//	POST /resume  run on changes again
+//	GET  /metrics counters and histograms in the Prometheus text format
+type apiServer struct {
+	srv     *http.Server
+	ws      *watchSet
+	watched func() []string
+	rules   *ruleSet
+	pauses  chan<- bool  // to the main loop, true pauses and false resumes
+	paused  *atomic.Bool // kept up to date by the main loop
+}
+
+// ruleStatus is a rule in GET /status.
+type ruleStatus struct {
+	Name     string     `json:"name,omitempty"`
+	Command  string     `json:"command"`
+	Running  bool       `json:"running"`
+	Runs     int        `json:"runs"`
+	Failed   int        `json:"failed"`
+	LastRun  *time.Time `json:"last_run,omitempty"`
+	ExitCode *int       `json:"exit_code,omitempty"`
+}
+
+func startAPI(addr string, origins []string, ws *watchSet, watched func() []string, rules *ruleSet, pauses chan<- bool, paused *atomic.Bool) (*apiServer, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	a := &apiServer{ws: ws, watched: watched, rules: rules, pauses: pauses, paused: paused}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", a.status)
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		registered, _ := ws.watchCount()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metrics.write(w, registered)
+	})
+	mux.HandleFunc("POST /run", func(w http.ResponseWriter, r *http.Request) {
+		logf("Run requested over HTTP\n")
+		rules.force()
+		a.ok(w)
+	})
+	mux.HandleFunc("POST /pause", func(w http.ResponseWriter, r *http.Request) {
+		a.pauses <- true
+		a.ok(w)
+	})
+	mux.HandleFunc("POST /resume", func(w http.ResponseWriter, r *http.Request) {
+		a.pauses <- false
+		a.ok(w)
+	})
+	// Pages of other sites could POST otherwise
+	a.srv = &http.Server{Handler: checkOrigin(mux, origins)}
+	go a.srv.Serve(ln)
+	return a, nil
+}
+
+func (a *apiServer) status(w http.ResponseWriter, r *http.Request) {
+	var rules []ruleStatus
+	for _, rule := range a.rules.rules {
+		running, lastEnd, lastCode := rule.run.state()
+		stats := rule.run.timings()
+		s := ruleStatus{
+			Name:    rule.name,
+			Command: rule.command,
+			Running: running,
+			Runs:    stats.n,
+			Failed:  stats.failed,
+		}
+		if !lastEnd.IsZero() {
+			s.LastRun, s.ExitCode = &lastEnd, &lastCode
+		}
+		rules = append(rules, s)
+	}
+	writeJSON(w, map[string]any{
+		"paused":  a.paused.Load(),
+		"watched": a.watched(),
+		"rules":   rules,
+	})
+}
+
+func (a *apiServer) ok(w http.ResponseWriter) {
+	writeJSON(w, map[string]any{"ok": true})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (a *apiServer) Close() error {
+	return a.srv.Close()
+}
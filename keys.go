@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"os"
+)
+
+// keypresses returns a channel receiving the keys pressed on tty, as
+// the commands of keyCommand, and a function restoring the terminal.
+// Where single keys cannot be read, each key is followed by Enter and
+// Enter alone re-runs.
+func keypresses(tty *os.File) (<-chan string, func()) {
+	keys := make(chan string)
+	restore, err := cbreak(tty)
+	if err != nil {
+		go func() {
+			scanner := bufio.NewScanner(tty)
+			for scanner.Scan() {
+				line := scanner.Text()
+				if line == "" {
+					line = "r"
+				}
+				if key := keyCommand(line[0]); key != "" {
+					keys <- key
+				}
+			}
+		}()
+		return keys, func() {}
+	}
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, err := tty.Read(buf)
+			if err != nil {
+				return
+			}
+			for _, b := range buf[:n] {
+				if key := keyCommand(b); key != "" {
+					keys <- key
+				}
+			}
+		}
+	}()
+	return keys, restore
+}
+
+// keyCommand returns what a key does: "r" re-runs, "p" pauses and
+// resumes, "c" clears the screen, "s" shows the watched files, "t" the
+// timings and "q" quits. Other keys do nothing and return "".
+func keyCommand(b byte) string {
+	switch b {
+	case 'r', 'R', '\r', '\n':
+		return "r"
+	case 'p', 'P':
+		return "p"
+	case 'c', 'C':
+		return "c"
+	case 's', 'S':
+		return "s"
+	case 't', 'T':
+		return "t"
+	case 'q', 'Q':
+		return "q"
+	}
+	return ""
+}
+
+// terminalInput returns the terminal to read keys from, or nil if there
+// is none or we run in the background. Stdin is only used when it is a
+// terminal and was not consumed by "-", otherwise /dev/tty is tried.
+func terminalInput(stdinUsed bool) *os.File {
+	tty := os.Stdin
+	if stdinUsed || !isTerminal(tty) {
+		f, err := os.Open("/dev/tty")
+		if err != nil {
+			return nil
+		}
+		tty = f
+	}
+	if !foreground(tty) {
+		return nil
+	}
+	return tty
+}
+
+// isTerminal reports whether f is a character device, like a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
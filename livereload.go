@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+const defaultLiveReloadPort = 35729
+
+// liveReload tells browsers to reload after successful runs, speaking
+// the LiveReload protocol that the browser extensions understand. Pages
+// can also include /livereload.js instead of using an extension, which
+// --serve does for them.
+type liveReload struct {
+	srv *http.Server
+
+	mu      sync.Mutex
+	clients map[*wsConn]bool
+}
+
+func newLiveReload() *liveReload {
+	return &liveReload{clients: map[*wsConn]bool{}}
+}
+
+// listen serves LiveReload on its own port, for --livereload.
+func (l *liveReload) listen(port int) error {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	l.register(mux)
+	l.srv = &http.Server{Handler: mux}
+	go l.srv.Serve(ln)
+	return nil
+}
+
+// register adds the LiveReload routes to mux.
+func (l *liveReload) register(mux *http.ServeMux) {
+	mux.HandleFunc("/livereload", l.serveWebSocket)
+	mux.HandleFunc("/livereload.js", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/javascript")
+		w.Write([]byte(liveReloadScript))
+	})
+}
+
+func (l *liveReload) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	c, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	l.mu.Lock()
+	l.clients[c] = true
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		delete(l.clients, c)
+		l.mu.Unlock()
+	}()
+
+	for {
+		msg, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		var cmd struct{ Command string }
+		if json.Unmarshal(msg, &cmd) == nil && cmd.Command == "hello" {
+			c.WriteText([]byte(`{"command":"hello","protocols":["http://livereload.com/protocols/official-7"],"serverName":"on_change"}`))
+		}
+	}
+}
+
+// reload tells every connected browser that path changed; stylesheets
+// are swapped without reloading the page.
+func (l *liveReload) reload(path string) {
+	msg, _ := json.Marshal(map[string]any{"command": "reload", "path": path, "liveCSS": true, "liveImg": true})
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for c := range l.clients {
+		if err := c.WriteText(msg); err != nil {
+			c.Close()
+			delete(l.clients, c)
+		}
+	}
+	if len(l.clients) > 0 {
+		debugf("livereload: reloading %d browser(s)", len(l.clients))
+	}
+}
+
+func (l *liveReload) Close() error {
+	if l.srv == nil {
+		return nil
+	}
+	return l.srv.Close()
+}
+
+// liveReloadScript connects to the server it was loaded from and reloads
+// the page, or only its stylesheets, when told to.
+const liveReloadScript = `(function () {
+  var src = new URL(document.currentScript.src);
+  var url = (src.protocol === 'https:' ? 'wss://' : 'ws://') + src.host + '/livereload';
+  function connect() {
+    var ws = new WebSocket(url);
+    ws.onopen = function () {
+      ws.send(JSON.stringify({command: 'hello', protocols: ['http://livereload.com/protocols/official-7']}));
+    };
+    ws.onmessage = function (e) {
+      var msg = JSON.parse(e.data);
+      if (msg.command !== 'reload') return;
+      if (/\.css$/.test(msg.path)) {
+        document.querySelectorAll('link[rel=stylesheet]').forEach(function (link) {
+          var href = new URL(link.href);
+          href.searchParams.set('livereload', Date.now());
+          link.href = href;
+        });
+        return;
+      }
+      location.reload();
+    };
+    ws.onclose = function () { setTimeout(connect, 1000); };
+  }
+  connect();
+})();
+`
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// logFile keeps a history of on_change's messages, for --log-file. It
+// is nil without the flag.
+var logFile *rotatingLog
+
+// ansiCodes are the color escapes stripped from messages in the log.
+var ansiCodes = regexp.MustCompile("\033\\[[0-9;]*m")
+
+// rotatingLog is a log file that is renamed to FILE.1 once it would
+// grow past max bytes; FILE.1 becomes FILE.2 and so on, keeping at most
+// keep old files.
+type rotatingLog struct {
+	path string
+	max  int64
+	keep int
+
+	mu    sync.Mutex
+	f     *os.File
+	size  int64
+	stdio bool // stdout and stderr follow the file, for --daemon
+}
+
+func openLog(path string, max int64, keep int) (*rotatingLog, error) {
+	l := &rotatingLog{path: path, max: max, keep: keep}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *rotatingLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, info.Size()
+	if l.stdio {
+		return redirectStdio(f)
+	}
+	return nil
+}
+
+// redirectStdio makes stdout and stderr write to the log file from now
+// on, also after it was rotated or reopened.
+func (l *rotatingLog) redirectStdio() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stdio = true
+	if l.f == nil {
+		return l.open()
+	}
+	return redirectStdio(l.f)
+}
+
+// reopen closes the file and opens its path again, after logrotate or
+// similar moved it away.
+func (l *rotatingLog) reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f != nil {
+		l.f.Close()
+		l.f = nil
+	}
+	return l.open()
+}
+
+// write appends an event to the log: as a JSON record with --log-format
+// json, otherwise as its message after a timestamp.
+func (l *rotatingLog) write(kind string, f fields) {
+	if l == nil {
+		return
+	}
+	now := time.Now()
+	msg, _ := f["message"].(string)
+	msg = ansiCodes.ReplaceAllString(msg, "")
+	var line []byte
+	if logJSON {
+		rec := maps.Clone(f)
+		if msg != "" {
+			rec["message"] = msg
+		}
+		rec["time"] = now.Format(time.RFC3339Nano)
+		rec["event"] = kind
+		b, err := json.Marshal(rec)
+		if err != nil {
+			return
+		}
+		line = append(b, '\n')
+	} else {
+		if msg == "" {
+			return
+		}
+		line = []byte(now.Format(time.RFC3339) + " " + msg + "\n")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return
+	}
+	if l.stdio {
+		// The command's output is written to it too
+		if info, err := l.f.Stat(); err == nil {
+			l.size = info.Size()
+		}
+	}
+	if l.size > 0 && l.size+int64(len(line)) > l.max {
+		if err := l.rotate(); err != nil {
+			warnf("Warning: rotating %s: %v\n", l.path, err)
+		}
+		if l.f == nil && l.open() != nil {
+			return
+		}
+	}
+	n, _ := l.f.Write(line)
+	l.size += int64(n)
+}
+
+// rotate moves the current file aside and starts a new one.
+func (l *rotatingLog) rotate() error {
+	l.f.Close()
+	l.f = nil
+	if l.keep == 0 {
+		os.Remove(l.path)
+		return l.open()
+	}
+	os.Remove(fmt.Sprintf("%s.%d", l.path, l.keep))
+	for i := l.keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+func (l *rotatingLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
@@ -1,179 +1,658 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
-	"time"
 
 	"github.com/fsnotify/fsnotify"
 )
 
-func executeCommand(command string, files []string) {
-	fmt.Printf("[%s] Executing: %s\n", strings.Join(files, ", "), command)
-	
-	// Use shell to execute the command to support pipes, redirects, etc.
-	cmd := exec.Command("sh", "-c", command)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	
-	err := cmd.Run()
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			fmt.Printf("[%s] Command exited with code %d\n", 
-				strings.Join(files, ", "), exitErr.ExitCode())
-		} else {
-			fmt.Printf("[%s] Command error: %v\n", strings.Join(files, ", "), err)
+// exitChanged is the exit status for --exit-on-change.
+const exitChanged = 2
+
+func main() {
+	if len(os.Args) > 2 && os.Args[1] == "config" && os.Args[2] == "validate" {
+		os.Exit(validate(os.Args[3:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		os.Exit(ctl(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		args, err := serveArgs(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
 		}
+		os.Exit(watch(args))
 	}
-	fmt.Println()
+	os.Exit(watch(os.Args[1:]))
 }
 
-func main() {
-	if len(os.Args) < 3 {
-		fmt.Fprintf(os.Stderr, "Usage: %s <file1> [file2 ...] -- <command>\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "Example: %s main.c utils.c -- 'make'\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "Example: %s *.go -- 'go build'\n", os.Args[0])
-		os.Exit(1)
-	}
-	
-	// Find the -- separator
-	separatorIndex := -1
-	for i, arg := range os.Args[1:] {
-		if arg == "--" {
-			separatorIndex = i + 1
-			break
-		}
-	}
-	
-	if separatorIndex == -1 || separatorIndex == 1 || separatorIndex == len(os.Args)-1 {
-		fmt.Fprintf(os.Stderr, "Error: Must specify files before -- and command after --\n")
-		fmt.Fprintf(os.Stderr, "Usage: %s <file1> [file2 ...] -- <command>\n", os.Args[0])
-		os.Exit(1)
-	}
-	
-	files := os.Args[1:separatorIndex]
-	command := strings.Join(os.Args[separatorIndex+1:], " ")
-	
-	// Expand globs and verify files exist
-	var watchedFiles []string
-	for _, pattern := range files {
-		matches, err := filepath.Glob(pattern)
+// validate runs "config validate [file]", by default on the nearest
+// .onchange.yml, and returns its exit status.
+func validate(args []string) int {
+	// Paths are relative to where on_change would run with the file:
+	// the directory of a config it finds itself, else this one
+	var file, dir string
+	switch len(args) {
+	case 0:
+		found, err := findConfig()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error processing pattern '%s': %v\n", pattern, err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
 		}
-		if len(matches) == 0 {
-			// Not a glob pattern, use as-is
-			matches = []string{pattern}
+		if found == "" {
+			fmt.Fprintf(os.Stderr, "Error: no %s found\n", configNames[0])
+			return 1
 		}
-		for _, file := range matches {
-			if _, err := os.Stat(file); err != nil {
-				fmt.Fprintf(os.Stderr, "Warning: Cannot stat file '%s': %v\n", file, err)
-			} else {
-				watchedFiles = append(watchedFiles, file)
-			}
+		file, dir = found, filepath.Dir(found)
+	case 1:
+		file = args[0]
+	default:
+		fmt.Fprintf(os.Stderr, "Usage: %s config validate [file]\n", os.Args[0])
+		return 1
+	}
+
+	errs := validateConfig(file, dir)
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	fmt.Printf("%s: OK\n", file)
+	return 0
+}
+
+// watch runs on_change with the arguments args and returns its exit
+// status.
+func watch(args []string) int {
+	opts, err := parseArgs(os.Args[0], args, os.Stderr)
+	if err != nil {
+		if err == flag.ErrHelp {
+			return 0
 		}
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
 	}
-	
-	if len(watchedFiles) == 0 {
-		fmt.Fprintf(os.Stderr, "Error: No valid files to watch\n")
-		os.Exit(1)
+	quiet, verbose, logJSON = opts.quiet, opts.verbose, opts.logFormat == "json"
+	clearOn, timeFormat = opts.clear, opts.timeFormat
+	color = isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == "" && runtime.GOOS != "windows"
+	titleOn = opts.title && isTerminal(os.Stdout) && !opts.quiet
+	if opts.emitConfig {
+		if err := emitConfig(os.Stdout, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		return 0
 	}
-	
-	// Create watcher
-	watcher, err := fsnotify.NewWatcher()
+	if opts.pidfile != "" {
+		if err := checkPidfile(opts.pidfile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --pidfile %v\n", err)
+			return 1
+		}
+	}
+	background := daemonized()
+	if opts.daemon && !background {
+		return daemonize()
+	}
+	if background {
+		// Its output ends up in a file
+		color, titleOn = false, false
+	}
+	if opts.logFile != "" {
+		if logFile, err = openLog(opts.logFile, int64(opts.logMaxSize), opts.logKeep); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --log-file: %v\n", err)
+			return 1
+		}
+		defer logFile.Close()
+	}
+	saveTitle()
+	defer restoreTitle()
+	if opts.journal {
+		if journal, err = openJournal(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --journal: %v\n", err)
+			return 1
+		}
+		defer journal.Close()
+	}
+	if opts.configFile != "" {
+		logf("Using %s\n", opts.configFile)
+	}
+	if opts.cwd != "" && opts.docker == "" && !isDir(opts.cwd) {
+		fmt.Fprintf(os.Stderr, "Error: --cwd %s is not a directory\n", opts.cwd)
+		return 1
+	}
+
+	vars, err := envVars(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	cred, err := lookupCredential(opts.user, opts.group)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	cgroups, err := newCgroupManager(opts.limits)
 	if err != nil {
-		log.Fatal(err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer cgroups.close()
+
+	stdinUsed := slices.Contains(opts.paths, "-")
+	opts.paths, err = readStdinPaths(opts.paths, os.Stdin, opts.nulPaths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	// Create watcher
+	var watcher notifier
+	if opts.poll.enabled {
+		watcher = newPoller(opts.poll.interval)
+	} else {
+		watcher, err = newHybridWatcher(defaultPollInterval)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 	defer watcher.Close()
-	
-	// Add files to watcher
-	for _, file := range watchedFiles {
-		err = watcher.Add(file)
+
+	// URLs and --remote paths are checked on a timer
+	var srcs []source
+	for _, spec := range opts.remotes {
+		src, err := newSSHSource(spec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		srcs = append(srcs, src)
+	}
+
+	// Add files and patterns to watcher
+	ws := newWatchSet(watcher, opts)
+	for _, pattern := range opts.paths {
+		if isURL(pattern) {
+			srcs = append(srcs, newURLSource(pattern))
+			continue
+		}
+		if err := ws.add(pattern); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Cannot watch '%s': %v\n", pattern, err)
+		}
+	}
+
+	// Edits to the script run it, with the new content
+	for _, file := range commandFiles(opts) {
+		if err := ws.add(file); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Cannot watch '%s': %v\n", file, err)
+		}
+	}
+
+	if registered, refused := ws.watchCount(); refused > 0 {
+		fmt.Fprintf(os.Stderr, "Error: %d watches needed but --max-watches is %d; narrow the paths or raise the limit\n",
+			registered+refused, opts.maxWatches)
+		return 1
+	}
+	if h, ok := watcher.(*hybridWatcher); ok {
+		if n := h.fallbacks(); n > 0 {
+			registered, _ := ws.watchCount()
+			fmt.Fprintf(os.Stderr, "Warning: %d watches needed, inotify allows %s; %d path(s) are polled instead. Raise it with: sysctl fs.inotify.max_user_watches=%d\n",
+				registered, maxUserWatches(), n, registered*2)
+		}
+	}
+
+	if n := ws.watchFailures(); n > 0 && !opts.poll.enabled {
+		fmt.Fprintf(os.Stderr, "Hint: %d path(s) could not be watched; on network filesystems or bind mounts try --poll\n", n)
+	}
+
+	remoteEvents, remotes := startRemotes(srcs, opts.interval)
+	defer stopRemotes(remotes)
+
+	if ws.empty() && len(remotes) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: No valid files to watch\n")
+		return 1
+	}
+
+	watched := func() []string {
+		list := ws.list()
+		for _, r := range remotes {
+			list = append(list, r.String())
+		}
+		return list
+	}
+
+	watchedFiles := watched()
+	specs := opts.rules
+	if opts.command != "" {
+		specs = append([]ruleSpec{{command: opts.command, argv: opts.argv}}, specs...)
+	} else if opts.commandFile != "" {
+		specs = append([]ruleSpec{{command: opts.commandFile}}, specs...)
+	}
+	if !ws.empty() {
+		list := ws.list()
+		logEvent("watch", fields{"paths": list}, "Watching %d path(s): %s\n", len(list), strings.Join(list, ", "))
+	}
+	for _, r := range remotes {
+		logf("Polling %s every %s\n", r, opts.interval)
+	}
+	// Every rule runs with the options of the command line, or with its
+	// own from the config file
+	ruleOpts := make([]*options, len(specs))
+	for i, spec := range specs {
+		ro := spec.opts
+		if ro == nil {
+			ro = opts.clone()
+			ro.command, ro.argv = spec.command, spec.argv
+		}
+		ruleOpts[i] = ro
+
+		where := ""
+		if ro.docker != "" {
+			where += " in container " + ro.docker
+		}
+		if ro.cwd != "" {
+			where += " (in " + ro.cwd + ")"
+		}
+		switch {
+		case spec.name != "":
+			logf("Will execute %s: %s%s\n", spec.name, spec.command, where)
+		case spec.pattern != "":
+			logf("Will execute on '%s': %s%s\n", spec.pattern, spec.command, where)
+		default:
+			logf("Will execute: %s%s\n", spec.command, where)
+		}
+	}
+	var tty *os.File
+	if !opts.forwardStdin {
+		tty = terminalInput(stdinUsed)
+	}
+	if opts.tui {
+		// Keys are shown on the dashboard
+	} else if tty != nil {
+		logf("Keys: r re-run, p pause, c clear, s watched files, t timings, q quit.\n\n")
+	} else {
+		logf("Press Ctrl+C to stop.\n\n")
+	}
+
+	var reload *liveReload
+	if opts.liveReload.enabled || opts.serve != "" {
+		reload = newLiveReload()
+	}
+	if opts.liveReload.enabled {
+		if err := reload.listen(opts.liveReload.port); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: livereload: %v\n", err)
+			return 1
+		}
+		defer reload.Close()
+		logf("LiveReload on port %d, or add <script src=\"http://localhost:%d/livereload.js\"></script>\n\n",
+			opts.liveReload.port, opts.liveReload.port)
+	}
+	if opts.wsAddr != "" || opts.grpcAddr != "" || opts.mqtt != "" {
+		eventStream = newEventHub()
+		defer eventStream.Close()
+	}
+	if opts.mqtt != "" {
+		mqtt, err := newMQTTPublisher(opts.mqtt, opts.mqttTopic)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --mqtt: %v\n", err)
+			return 1
+		}
+		mqtt.start(eventStream)
+		defer mqtt.close()
+	}
+	if opts.wsAddr != "" {
+		if err := eventStream.listen(opts.wsAddr, opts.origins); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --ws: %v\n", err)
+			return 1
+		}
+	}
+	if opts.serve != "" {
+		srv, err := startStaticServer(opts.serveAddr, opts.serve, reload)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error watching '%s': %v\n", file, err)
-		}
-	}
-	
-	fmt.Printf("Watching %d file(s): %s\n", len(watchedFiles), strings.Join(watchedFiles, ", "))
-	fmt.Printf("Will execute: %s\n", command)
-	fmt.Println("Press Ctrl+C to stop.\n")
-	
-	// Initial execution
-	executeCommand(command, watchedFiles)
-	
-	// Debouncing: collect events for a short period before executing
-	var mu sync.Mutex
-	var timer *time.Timer
-	lastExec := time.Now()
-	
-	// Handle Ctrl+C
+			fmt.Fprintf(os.Stderr, "Error: serve: %v\n", err)
+			return 1
+		}
+		defer srv.Close()
+		logf("Serving %s on http://%s/\n\n", opts.serve, opts.serveAddr)
+	}
+
+	signals := newRunSignals()
+	rules := &ruleSet{filter: ws.filter, signals: signals}
+	defer rules.shutdown()
+	defer rules.printSummary()
+	locks := map[string]*sync.Mutex{}
+	// One reader of stdin, passing it to whichever rule's command runs
+	var stdin *stdinForwarder
+	if opts.forwardStdin {
+		stdin = newStdinForwarder(os.Stdin)
+	}
+	for i, spec := range specs {
+		ro := ruleOpts[i]
+		vars := vars
+		var filter *pathFilter
+		if spec.opts != nil {
+			if vars, err = envVars(ro); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: rule %s: %v\n", spec.name, err)
+				return 1
+			}
+			filter = newPathFilter(ro)
+		}
+
+		var hashes *contentHashes
+		if ro.hash {
+			hashes = newContentHashes()
+			hashes.seed(ws)
+		}
+		run := newRunner(ro, vars, cred, cgroups)
+		run.liveReload = reload
+		run.stdin = stdin
+		run.rule = spec.name
+		if run.rule == "" {
+			run.rule = spec.command
+		}
+		// Debouncing: collect events for a short period before executing
+		sched := newScheduler(ro, ws.filter, run, watched, hashes, signals)
+		if spec.lock != "" {
+			if locks[spec.lock] == nil {
+				locks[spec.lock] = &sync.Mutex{}
+			}
+			sched.lock = locks[spec.lock]
+		}
+		rules.rules = append(rules.rules, &rule{
+			pattern: spec.pattern,
+			command: spec.command,
+			run:     run,
+			sched:   sched,
+			name:    spec.name,
+			paths:   spec.paths,
+			filter:  filter,
+		})
+	}
+
+	var keys <-chan string
+	if opts.tui {
+		if tty == nil {
+			fmt.Fprintf(os.Stderr, "Error: --tui needs a terminal\n")
+			return 1
+		}
+		d, err := startDashboard(tty, watched)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		// Closed before the summary is printed
+		defer d.close()
+		keys = d.keys
+	} else if tty != nil {
+		var restore func()
+		keys, restore = keypresses(tty)
+		defer restore()
+	}
+
+	// Pausing is asked for on the main loop's channel; the others see
+	// whether it is paused
+	pauses := make(chan bool)
+	var pausedNow atomic.Bool
+	if opts.control != "" {
+		ctl, err := startControl(opts.control, ws, rules, pauses, &pausedNow)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: control socket: %v\n", err)
+			return 1
+		}
+		defer ctl.Close()
+	} else if path, err := runtimeSocket(); err == nil {
+		// For "on_change ctl", without --control
+		if ctl, err := startControl(path, ws, rules, pauses, &pausedNow); err == nil {
+			defer ctl.Close()
+		} else {
+			debugf("control socket %s: %v", path, err)
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "Warning: no control socket for on_change ctl: %v\n", err)
+	}
+	if opts.httpAddr != "" {
+		api, err := startAPI(opts.httpAddr, opts.origins, ws, watched, rules, pauses, &pausedNow)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --http: %v\n", err)
+			return 1
+		}
+		defer api.Close()
+	}
+
+	if opts.grpcAddr != "" {
+		srv, err := startGRPC(opts.grpcAddr, ws, rules, pauses)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --grpc: %v\n", err)
+			return 1
+		}
+		defer srv.Stop()
+	}
+
+	if opts.pidfile != "" {
+		remove, err := writePidfile(opts.pidfile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --pidfile %v\n", err)
+			return 1
+		}
+		defer remove()
+	}
+	// From here on in the background with --daemon
+	var hangup <-chan os.Signal
+	if background {
+		if err := daemonReady(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --daemon: %v\n", err)
+			return 1
+		}
+		hangup = hangups()
+	}
+
+	// Watching, as far as systemd is concerned
+	sdNotify(fmt.Sprintf("READY=1\nSTATUS=Watching %d path(s)", len(watchedFiles)))
+	defer sdNotify("STOPPING=1")
+	if d := watchdogInterval(); d > 0 {
+		// Pinged on its own, the initial run may take longer than
+		// WatchdogSec=
+		stop := make(chan struct{})
+		defer close(stop)
+		go pingWatchdog(d, stop)
+	}
+
+	// Initial execution, unless only changes should run the command
+	initial := !opts.noInitial && !opts.once && !opts.exitOnChange
+	if initial && opts.initialIfStale != "" && !ws.newerThan(opts.initialIfStale) {
+		logf("%s is up to date, skipping the initial run\n", opts.initialIfStale)
+		initial = false
+	}
+
+	exitStatus := func() int {
+		if opts.exitStatus {
+			return rules.exitStatus()
+		}
+		return 0
+	}
+
+	// Handle Ctrl+C, also during the initial run: the command runs in a
+	// process group of its own and would outlive us otherwise
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	
+
+	// SIGUSR1 runs the command, SIGUSR2 pauses and resumes watching.
+	// While paused, the watches stay and changes are remembered for
+	// --run-on-resume.
+	rerun, pause := controlSignals()
+	paused := false
+	missed := map[string]fsnotify.Op{}
+	setPaused := func(p bool) {
+		if p == paused {
+			return
+		}
+		paused = p
+		pausedNow.Store(paused)
+		if d := shownDashboard(); d != nil {
+			d.setPaused(paused)
+		}
+		if paused {
+			logf("Paused, changes are ignored until resumed with p, SIGUSR2, the control socket or the HTTP API\n")
+			return
+		}
+		if len(missed) > 0 && opts.runOnResume {
+			logf("Resumed, %d path(s) changed meanwhile\n", len(missed))
+			for _, name := range sortedKeys(missed) {
+				rules.add(name, missed[name])
+			}
+		} else {
+			logf("Resumed\n")
+		}
+		clear(missed)
+	}
+	if initial {
+		clearBeforeRun()
+		done := make(chan struct{})
+		go func() {
+			rules.runAll(initialChange(watchedFiles))
+			close(done)
+		}()
+		// The control socket and the APIs wait for their pause to be taken
+	initialRun:
+		for {
+			select {
+			case <-done:
+				break initialRun
+			case p := <-pauses:
+				setPaused(p)
+			case <-sigChan:
+				logEvent("stop", nil, "\nStopping file watcher...\n")
+				return exitStatus()
+			}
+		}
+	}
+
+	changed := func(name string, op fsnotify.Op) {
+		if paused {
+			debugf("not running for %s: paused", name)
+			missed[name] |= op
+			return
+		}
+		rules.add(name, op)
+	}
+
 	for {
 		select {
-		case event, ok := <-watcher.Events:
+		case event, ok := <-watcher.events():
 			if !ok {
-				return
+				return exitStatus()
 			}
-			
-			// Filter out some events we don't care about
-			if event.Op&fsnotify.Chmod == fsnotify.Chmod {
-				continue // Skip permission-only changes
+
+			// fsnotify joins names onto the watched path verbatim ("./a.go")
+			event.Name = filepath.Clean(event.Name)
+
+			debugf("event %s %s", event.Op, event.Name)
+			appeared := ws.update(event)
+			if opts.events.mask&fsnotify.Create == 0 {
+				appeared = nil
 			}
-			
-			mu.Lock()
-			if timer != nil {
-				timer.Stop()
+
+			// Filter out events we don't care about, by default
+			// permission-only changes
+			reason := ws.irrelevant(event.Name)
+			if event.Op&opts.events.mask == 0 {
+				reason = event.Op.String() + " is not in --events"
 			}
-			
-			// Debounce: wait 100ms for more changes before executing
-			timer = time.AfterFunc(100*time.Millisecond, func() {
-				mu.Lock()
-				defer mu.Unlock()
-				
-				// Prevent executing too frequently (min 500ms between executions)
-				if time.Since(lastExec) < 500*time.Millisecond {
-					return
-				}
-				
-				now := time.Now()
-				fmt.Printf("[%s] Change detected at %s\n", 
-					filepath.Base(event.Name), now.Format("15:04:05"))
-				
-				executeCommand(command, watchedFiles)
-				lastExec = now
-				
-				// Re-add file if it was removed and recreated
-				if event.Op&fsnotify.Remove == fsnotify.Remove {
-					// Try to re-add after a short delay
-					go func() {
-						time.Sleep(100 * time.Millisecond)
-						if _, err := os.Stat(event.Name); err == nil {
-							watcher.Add(event.Name)
-						}
-					}()
+			metrics.event(reason != "")
+			if reason != "" {
+				debugf("ignoring %s: %s", event.Name, reason)
+				if len(appeared) == 0 {
+					continue
 				}
-			})
-			mu.Unlock()
-			
-		case err, ok := <-watcher.Errors:
+			}
+
+			changed(event.Name, event.Op)
+			for _, p := range appeared {
+				changed(p, fsnotify.Create)
+			}
+
+		case event := <-remoteEvents:
+			debugf("event %s %s", event.Op, event.Name)
+			if event.Op&opts.events.mask == 0 || !ws.filter.accepts(event.Name) {
+				metrics.event(true)
+				debugf("ignoring %s", event.Name)
+				continue
+			}
+			metrics.event(false)
+			changed(event.Name, event.Op)
+
+		case err, ok := <-watcher.errors():
 			if !ok {
-				return
+				return exitStatus()
+			}
+			warnf("Error: %v\n", err)
+
+		case key := <-keys:
+			switch key {
+			case "s":
+				list := watched()
+				logf("Watching %d path(s):\n  %s\n", len(list), strings.Join(list, "\n  "))
+			case "t":
+				rules.printStats()
+			case "c":
+				if d := shownDashboard(); d != nil {
+					d.clearOutput()
+				} else if isTerminal(os.Stdout) {
+					clearScreen(false)
+				}
+			case "p":
+				setPaused(!paused)
+			case "q":
+				logEvent("stop", nil, "\nStopping file watcher...\n")
+				return exitStatus()
+			default:
+				rules.force()
 			}
-			fmt.Printf("Error: %v\n", err)
-			
+
+		case <-rerun:
+			rules.force()
+
+		case <-pause:
+			setPaused(!paused)
+
+		case p := <-pauses:
+			setPaused(p)
+
+		case <-hangup:
+			if logFile == nil {
+				continue
+			}
+			if err := logFile.reopen(); err != nil {
+				warnf("Error: reopening %s: %v\n", opts.logFile, err)
+				continue
+			}
+			logf("Reopened %s\n", opts.logFile)
+
+		case <-signals.ran:
+			if opts.once {
+				return exitStatus()
+			}
+
+		case <-signals.detected:
+			return exitChanged
+
 		case <-sigChan:
-			fmt.Println("\nStopping file watcher...")
-			return
+			logEvent("stop", nil, "\nStopping file watcher...\n")
+			return exitStatus()
 		}
 	}
 }
@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestMatchDoublestar(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "cmd/main.go", false},
+		{"**", "a/b/c", true},
+		{"**", "", true},
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "cmd/tool/main.go", true},
+		{"**/*.go", "cmd/tool/main.c", false},
+		{"src/**", "src", true},
+		{"src/**", "src/a/b.c", true},
+		{"src/**", "lib/a.c", false},
+		{"src/**/test", "src/test", true},
+		{"src/**/test", "src/a/b/test", true},
+		{"src/**/test", "src/a/b/test/x", false},
+		{"a/**/b/**/c", "a/x/b/y/z/c", true},
+		{"a/**/b/**/c", "a/x/y/z/c", false},
+		{"a/*/c", "a/b/c", true},
+		{"a/*/c", "a/b/d/c", false},
+		{"[ab].txt", "b.txt", true},
+		{"[ab].txt", "c.txt", false},
+		{"a?c", "abc", true},
+		{"a?c", "a/c", false},
+		{"build", "build", true},
+		{"build", "build/out", false},
+	}
+	for _, tt := range tests {
+		if got := matchDoublestar(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchDoublestar(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metrics are counted for GET /metrics of the HTTP API, in the
+// Prometheus text format.
+var metrics = &metricSet{
+	runs:      map[string]uint64{},
+	failures:  map[string]uint64{},
+	durations: map[string]*histogram{},
+	debounce:  newHistogram(),
+}
+
+// durationBuckets are the upper bounds of the histograms, in seconds.
+var durationBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+type metricSet struct {
+	mu        sync.Mutex
+	events    uint64
+	filtered  uint64
+	runs      map[string]uint64 // by rule
+	failures  map[string]uint64
+	durations map[string]*histogram
+	debounce  *histogram
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(durationBuckets))}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	v := d.Seconds()
+	if i, _ := slices.BinarySearch(durationBuckets, v); i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+// event counts a file event, and whether it was ignored.
+func (m *metricSet) event(filtered bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events++
+	if filtered {
+		m.filtered++
+	}
+}
+
+func (m *metricSet) runStarted(rule string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runs[rule]++
+}
+
+func (m *metricSet) runFinished(rule string, d time.Duration, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if failed {
+		m.failures[rule]++
+	}
+	if m.durations[rule] == nil {
+		m.durations[rule] = newHistogram()
+	}
+	m.durations[rule].observe(d)
+}
+
+// debounced records how long after the first change of a batch its run
+// started.
+func (m *metricSet) debounced(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.debounce.observe(d)
+}
+
+// write writes the metrics in the Prometheus text format.
+func (m *metricSet) write(w io.Writer, watches int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counter := func(name, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	}
+	counter("on_change_events_total", "File events received.")
+	fmt.Fprintf(w, "on_change_events_total %d\n", m.events)
+	counter("on_change_events_filtered_total", "File events ignored by the filters.")
+	fmt.Fprintf(w, "on_change_events_filtered_total %d\n", m.filtered)
+	counter("on_change_runs_total", "Commands started.")
+	for _, rule := range sortedRules(m.runs) {
+		fmt.Fprintf(w, "on_change_runs_total{rule=%s} %d\n", quoteLabel(rule), m.runs[rule])
+	}
+	counter("on_change_run_failures_total", "Runs that failed or timed out.")
+	for _, rule := range sortedRules(m.runs) {
+		fmt.Fprintf(w, "on_change_run_failures_total{rule=%s} %d\n", quoteLabel(rule), m.failures[rule])
+	}
+
+	fmt.Fprintf(w, "# HELP on_change_run_duration_seconds How long runs took.\n# TYPE on_change_run_duration_seconds histogram\n")
+	for _, rule := range sortedRules(m.durations) {
+		m.durations[rule].write(w, "on_change_run_duration_seconds", "rule="+quoteLabel(rule)+",")
+	}
+	fmt.Fprintf(w, "# HELP on_change_debounce_latency_seconds Time from the first change of a batch to its run.\n# TYPE on_change_debounce_latency_seconds histogram\n")
+	m.debounce.write(w, "on_change_debounce_latency_seconds", "")
+
+	fmt.Fprintf(w, "# HELP on_change_watches Paths being watched.\n# TYPE on_change_watches gauge\n")
+	fmt.Fprintf(w, "on_change_watches %d\n", watches)
+}
+
+// write writes the series of h, with labels ending in a comma.
+func (h *histogram) write(w io.Writer, name, labels string) {
+	var cumulative uint64
+	for i, le := range durationBuckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%g\"} %d\n", name, labels, le, cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+	labels = strings.TrimSuffix(labels, ",")
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %g\n%s_count%s %d\n", name, labels, h.sum, name, labels, h.count)
+}
+
+func sortedRules[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// quoteLabel quotes a label value as Prometheus expects.
+func quoteLabel(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
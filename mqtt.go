@@ -0,0 +1,287 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	mqttKeepAlive = 60 * time.Second
+	mqttRetry     = 5 * time.Second
+)
+
+// mqttPublisher publishes changes and the results of runs to an MQTT
+// broker, for --mqtt. It speaks just enough MQTT 3.1.1 for that: it
+// connects with a clean session and publishes with QoS 0. While the
+// broker cannot be reached, events are dropped.
+type mqttPublisher struct {
+	addr     string
+	tls      bool
+	username *string
+	password *string
+	clientID string
+	topic    string
+
+	conn    net.Conn
+	lost    chan net.Conn // connections whose reader failed
+	retryAt time.Time
+	warned  bool
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// newMQTTPublisher parses a broker URL as mqtt://[user[:password]@]host[:port],
+// or mqtts:// for TLS. The topic may contain {event} and {hostname}.
+func newMQTTPublisher(broker, topic string) (*mqttPublisher, error) {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return nil, err
+	}
+	p := &mqttPublisher{topic: topic, lost: make(chan net.Conn, 1), done: make(chan struct{}), stopped: make(chan struct{})}
+	port := "1883"
+	switch u.Scheme {
+	case "mqtt", "tcp":
+	case "mqtts", "ssl", "tls":
+		p.tls, port = true, "8883"
+	default:
+		return nil, fmt.Errorf("%s: the scheme must be mqtt:// or mqtts://", broker)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("%s: no host", broker)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	p.addr = net.JoinHostPort(u.Hostname(), port)
+	if u.User != nil {
+		name := u.User.Username()
+		p.username = &name
+		if pass, ok := u.User.Password(); ok {
+			p.password = &pass
+		}
+	}
+	hostname, _ := os.Hostname()
+	p.clientID = fmt.Sprintf("on_change-%s-%d", hostname, os.Getpid())
+	if len(p.clientID) > 23 {
+		// The longest id every broker accepts
+		p.clientID = p.clientID[len(p.clientID)-23:]
+	}
+	p.topic = strings.ReplaceAll(p.topic, "{hostname}", hostname)
+	return p, nil
+}
+
+// start publishes the change and exit records of hub until close.
+func (p *mqttPublisher) start(hub *eventHub) {
+	records, cancel := hub.subscribe()
+	go func() {
+		defer close(p.stopped)
+		defer cancel()
+		ping := time.NewTicker(mqttKeepAlive / 2)
+		defer ping.Stop()
+		for {
+			select {
+			case b := <-records:
+				p.publish(b)
+			case <-ping.C:
+				p.send([]byte{0xc0, 0}) // PINGREQ
+			case conn := <-p.lost:
+				if conn == p.conn {
+					p.drop()
+				}
+			case <-p.done:
+				// What was logged while stopping
+				for len(records) > 0 {
+					p.publish(<-records)
+				}
+				p.send([]byte{0xe0, 0}) // DISCONNECT
+				if p.conn != nil {
+					p.conn.Close()
+				}
+				return
+			}
+		}
+	}()
+}
+
+// publish sends a record to the topic, if it is a change or the result
+// of a run.
+func (p *mqttPublisher) publish(record []byte) {
+	var rec struct{ Event string }
+	if json.Unmarshal(record, &rec) != nil || (rec.Event != "change" && rec.Event != "exit") {
+		return
+	}
+	if p.conn == nil && !p.connect() {
+		return
+	}
+	topic := strings.ReplaceAll(p.topic, "{event}", rec.Event)
+	body := mqttString(nil, topic)
+	body = append(body, record...)
+	p.send(mqttPacket(0x30, body)) // PUBLISH, QoS 0
+}
+
+// connect dials the broker, at most every mqttRetry, and reports
+// whether it is connected.
+func (p *mqttPublisher) connect() bool {
+	if time.Now().Before(p.retryAt) {
+		return false
+	}
+	conn, err := p.dial()
+	if err != nil {
+		p.retryAt = time.Now().Add(mqttRetry)
+		if !p.warned {
+			warnf("Warning: mqtt %s: %v, dropping events until it is back\n", p.addr, err)
+			p.warned = true
+		}
+		debugf("mqtt %s: %v", p.addr, err)
+		return false
+	}
+	if p.warned {
+		logf("Connected to MQTT broker %s again\n", p.addr)
+		p.warned = false
+	}
+	p.conn = conn
+	go p.read(conn)
+	return true
+}
+
+// read consumes the answers to PINGREQ until the connection fails, which
+// is how a broker going away is noticed between publishes.
+func (p *mqttPublisher) read(conn net.Conn) {
+	_, err := io.Copy(io.Discard, conn)
+	if err == nil {
+		err = io.EOF
+	}
+	debugf("mqtt %s: %v", p.addr, err)
+	select {
+	case p.lost <- conn:
+	case <-p.stopped:
+	}
+}
+
+// drop closes the connection after it failed; the next publish
+// reconnects.
+func (p *mqttPublisher) drop() {
+	warnf("Warning: mqtt %s: connection lost, reconnecting\n", p.addr)
+	p.warned = true
+	p.conn.Close()
+	p.conn = nil
+}
+
+func (p *mqttPublisher) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	var conn net.Conn
+	var err error
+	if p.tls {
+		conn, err = tls.DialWithDialer(dialer, "tcp", p.addr, nil)
+	} else {
+		conn, err = dialer.Dial("tcp", p.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// CONNECT with a clean session
+	flags := byte(0x02)
+	body := mqttString(nil, "MQTT")
+	body = append(body, 4, 0) // protocol level 4 is MQTT 3.1.1; flags follow
+	body = binary.BigEndian.AppendUint16(body, uint16(mqttKeepAlive/time.Second))
+	body = mqttString(body, p.clientID)
+	if p.username != nil {
+		flags |= 0x80
+		body = mqttString(body, *p.username)
+	}
+	if p.password != nil {
+		flags |= 0x40
+		body = mqttString(body, *p.password)
+	}
+	body[7] = flags
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Write(mqttPacket(0x10, body)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// CONNACK
+	var ack [4]byte
+	if _, err := io.ReadFull(conn, ack[:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if ack[0] != 0x20 {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected packet 0x%02x instead of CONNACK", ack[0])
+	}
+	if ack[3] != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("connection refused: %s", mqttRefusal(ack[3]))
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// send writes a packet, dropping the connection if that fails.
+func (p *mqttPublisher) send(packet []byte) {
+	if p.conn == nil {
+		return
+	}
+	p.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := p.conn.Write(packet); err != nil {
+		debugf("mqtt %s: %v", p.addr, err)
+		p.drop()
+	}
+}
+
+// close disconnects from the broker after publishing what is left.
+func (p *mqttPublisher) close() {
+	close(p.done)
+	<-p.stopped
+}
+
+// mqttPacket prefixes body with the fixed header: the packet type and
+// the remaining length.
+func mqttPacket(typ byte, body []byte) []byte {
+	packet := []byte{typ}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(packet, body...)
+}
+
+// mqttString appends s with its length.
+func mqttString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func mqttRefusal(code byte) string {
+	switch code {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "client id rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad user name or password"
+	case 5:
+		return "not authorized"
+	}
+	return fmt.Sprintf("code %d", code)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// notifier is the part of fsnotify.Watcher on_change relies on, so the
+// polling implementation can stand in for it.
+type notifier interface {
+	Add(name string) error
+	Remove(name string) error
+	Close() error
+	events() <-chan fsnotify.Event
+	errors() <-chan error
+}
+
+// fsWatcher adapts fsnotify.Watcher to notifier.
+type fsWatcher struct {
+	*fsnotify.Watcher
+}
+
+func newFsWatcher() (*fsWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &fsWatcher{w}, nil
+}
+
+func (w *fsWatcher) events() <-chan fsnotify.Event { return w.Events }
+func (w *fsWatcher) errors() <-chan error          { return w.Errors }
+
+// hybridWatcher uses fsnotify and falls back to polling the paths the
+// kernel refuses to watch because the inotify watch limit was reached,
+// instead of silently watching only part of the tree.
+type hybridWatcher struct {
+	fs       *fsWatcher
+	interval time.Duration
+	evs      chan fsnotify.Event
+	errs     chan error
+	done     chan struct{}
+
+	mu     sync.Mutex
+	poll   *poller // created on the first fallback
+	polled map[string]bool
+}
+
+func newHybridWatcher(interval time.Duration) (*hybridWatcher, error) {
+	fw, err := newFsWatcher()
+	if err != nil {
+		return nil, err
+	}
+	h := &hybridWatcher{
+		fs:       fw,
+		interval: interval,
+		evs:      make(chan fsnotify.Event),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+		polled:   map[string]bool{},
+	}
+	go h.forward(fw.Events, fw.Errors)
+	return h, nil
+}
+
+func (h *hybridWatcher) events() <-chan fsnotify.Event { return h.evs }
+func (h *hybridWatcher) errors() <-chan error          { return h.errs }
+
+func (h *hybridWatcher) Add(name string) error {
+	err := h.fs.Add(name)
+	if !errors.Is(err, syscall.ENOSPC) {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.poll == nil {
+		warnf("Warning: inotify watch limit reached (fs.inotify.max_user_watches=%s), polling the remaining paths every %s\n",
+			maxUserWatches(), h.interval)
+		h.poll = newPoller(h.interval)
+		go h.forward(h.poll.evs, h.poll.errs)
+	}
+	if err := h.poll.Add(name); err != nil {
+		return err
+	}
+	h.polled[name] = true
+	return nil
+}
+
+func (h *hybridWatcher) Remove(name string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.polled[name] {
+		delete(h.polled, name)
+		return h.poll.Remove(name)
+	}
+	return h.fs.Remove(name)
+}
+
+func (h *hybridWatcher) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	select {
+	case <-h.done:
+		return nil
+	default:
+		close(h.done)
+	}
+	if h.poll != nil {
+		h.poll.Close()
+	}
+	return h.fs.Close()
+}
+
+// fallbacks returns how many paths are polled instead of watched.
+func (h *hybridWatcher) fallbacks() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.polled)
+}
+
+func (h *hybridWatcher) forward(evs <-chan fsnotify.Event, errs <-chan error) {
+	for {
+		select {
+		case ev, ok := <-evs:
+			if !ok {
+				return
+			}
+			select {
+			case h.evs <- ev:
+			case <-h.done:
+				return
+			}
+		case err, ok := <-errs:
+			if !ok {
+				return
+			}
+			select {
+			case h.errs <- err:
+			case <-h.done:
+				return
+			}
+		case <-h.done:
+			return
+		}
+	}
+}
+
+// maxUserWatches returns the kernel's inotify watch limit, or "unknown".
+func maxUserWatches() string {
+	b, err := os.ReadFile("/proc/sys/fs/inotify/max_user_watches")
+	if err != nil {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(b))
+}
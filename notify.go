@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// notify shows a desktop notification with notify-send, osascript or a
+// PowerShell balloon tip, depending on the platform. It does not wait
+// for it, and failures only show with --verbose.
+func notify(title, body string, failed bool) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptString(body), appleScriptString(title))
+		cmd = exec.Command("osascript", "-e", script)
+	case "windows":
+		icon, tip := "Information", "Info"
+		if failed {
+			icon, tip = "Error", "Error"
+		}
+		script := fmt.Sprintf("Add-Type -AssemblyName System.Windows.Forms; "+
+			"$n = New-Object System.Windows.Forms.NotifyIcon; "+
+			"$n.Icon = [System.Drawing.SystemIcons]::%s; $n.Visible = $true; "+
+			"$n.ShowBalloonTip(5000, %s, %s, '%s'); Start-Sleep 6; $n.Dispose()",
+			icon, powerShellString(title), powerShellString(body), tip)
+		cmd = exec.Command("powershell", "-NoProfile", "-Command", script)
+	default:
+		urgency := "normal"
+		if failed {
+			urgency = "critical"
+		}
+		cmd = exec.Command("notify-send", "-u", urgency, "-a", "on_change", title, body)
+	}
+	go func() {
+		if err := cmd.Run(); err != nil {
+			debugf("notification failed: %v", err)
+		}
+	}()
+}
+
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func powerShellString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
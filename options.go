@@ -0,0 +1,957 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// options holds everything parsed from the command line.
+type options struct {
+	recursive   bool
+	maxDepth    int
+	noGitignore bool
+	hidden      bool
+	excludes    stringList
+	exts        stringList
+
+	noDefaultIgnores bool
+
+	filters       regexList
+	ignoreRegexes regexList
+
+	poll           pollFlag
+	maxWatches     int
+	followSymlinks bool
+	hash           bool
+	events         eventsFlag
+	attrib         bool
+
+	nulPaths bool
+
+	remotes  stringList
+	interval time.Duration
+
+	control string
+
+	debounce durationRules
+	throttle durationRules
+
+	restart     bool
+	signal      signalFlag
+	killTimeout time.Duration
+
+	noShell    bool
+	shell      string
+	perFile    bool
+	argsAppend bool
+	stdinFiles stdinFilesFlag
+	jobs       int
+
+	cancelOnChange bool
+	timeout        time.Duration
+	retries        int
+
+	onSuccess string
+	onFailure string
+	before    string
+	after     string
+	cwd       string
+
+	envs     stringList
+	envFiles stringList
+	cleanEnv bool
+
+	user  string
+	group string
+
+	docker string
+
+	exitStatus bool
+	once       bool
+
+	exitOnChange bool
+	noInitial    bool
+
+	initialIfStale string
+	dryRun         bool
+
+	nice   int
+	ionice ioniceFlag
+	limits cgroupLimits
+
+	pty          bool
+	forwardStdin bool
+
+	rules       ruleList
+	commandFile string
+	configFile  string
+
+	quiet       bool
+	verbose     bool
+	logFormat   string
+	clear       clearFlag
+	timeFormat  string
+	prefix      string
+	notify      bool
+	bell        bool
+	status      bool
+	logFile     string
+	logMaxSize  byteSize
+	logKeep     int
+	tui         bool
+	runOnResume bool
+	title       bool
+	collapse    collapseFlag
+	liveReload  portFlag
+	serve       string
+	serveAddr   string
+	httpAddr    string
+	webhooks    stringList
+	chat        stringList
+	wsAddr      string
+	origins     stringList
+	grpcAddr    string
+	journal     bool
+	daemon      bool
+	pidfile     string
+	mqtt        string
+	mqttTopic   string
+
+	chatRecovered         bool
+	chatTemplate          string
+	chatRecoveredTemplate string
+
+	emitConfig bool
+	given      []*flag.Flag // with --emit-config, the flags that were set
+
+	paths   []string
+	command string
+	argv    []string
+}
+
+// defaultRemoteInterval is how often URLs and --remote sources are checked.
+const defaultRemoteInterval = 5 * time.Second
+
+// stringList is a flag.Value collecting every occurrence of a
+// repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// regexList is a flag.Value compiling every occurrence of a repeatable
+// regular expression flag.
+type regexList []*regexp.Regexp
+
+func (l *regexList) String() string {
+	var s []string
+	for _, re := range *l {
+		s = append(s, re.String())
+	}
+	return strings.Join(s, ",")
+}
+
+func (l *regexList) Set(value string) error {
+	re, err := regexp.Compile(value)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, re)
+	return nil
+}
+
+// pollFlag is --poll, which may be given bare or with an interval.
+type pollFlag struct {
+	enabled  bool
+	interval time.Duration
+}
+
+func (p *pollFlag) IsBoolFlag() bool { return true }
+
+func (p *pollFlag) String() string {
+	if !p.enabled {
+		return ""
+	}
+	return p.interval.String()
+}
+
+func (p *pollFlag) Set(value string) error {
+	switch value {
+	case "true":
+		p.enabled, p.interval = true, defaultPollInterval
+	case "false":
+		p.enabled = false
+	default:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		if d <= 0 {
+			return errors.New("interval must be positive")
+		}
+		p.enabled, p.interval = true, d
+	}
+	return nil
+}
+
+// portFlag is a server that is off by default and listens on a default
+// port with the bare flag, like --livereload, or on the given one.
+type portFlag struct {
+	enabled bool
+	port    int
+	def     int
+}
+
+func (p *portFlag) IsBoolFlag() bool { return true }
+
+func (p *portFlag) String() string {
+	if p == nil || !p.enabled {
+		return ""
+	}
+	return strconv.Itoa(p.port)
+}
+
+func (p *portFlag) Set(value string) error {
+	switch value {
+	case "true":
+		p.enabled, p.port = true, p.def
+	case "false":
+		p.enabled = false
+	default:
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > 65535 {
+			return errors.New("expected a port number")
+		}
+		p.enabled, p.port = true, n
+	}
+	return nil
+}
+
+// collapseFlag is --collapse, holding back the command's output unless
+// the run fails; --collapse=N shows the last N lines of successful runs.
+type collapseFlag struct {
+	enabled bool
+	lines   int
+}
+
+func (c *collapseFlag) IsBoolFlag() bool { return true }
+
+func (c *collapseFlag) String() string {
+	if !c.enabled {
+		return ""
+	}
+	return strconv.Itoa(c.lines)
+}
+
+func (c *collapseFlag) Set(value string) error {
+	switch value {
+	case "true":
+		c.enabled, c.lines = true, 0
+	case "false":
+		c.enabled = false
+	default:
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return errors.New("expected a number of lines")
+		}
+		c.enabled, c.lines = true, n
+	}
+	return nil
+}
+
+// defaultEvents is every kind of change except permission-only ones.
+const defaultEvents = fsnotify.Create | fsnotify.Write | fsnotify.Remove | fsnotify.Rename
+
+var eventNames = map[string]fsnotify.Op{
+	"create": fsnotify.Create,
+	"write":  fsnotify.Write,
+	"remove": fsnotify.Remove,
+	"rename": fsnotify.Rename,
+	"chmod":  fsnotify.Chmod,
+	"all":    defaultEvents | fsnotify.Chmod,
+}
+
+// eventsFlag is --events, a set of fsnotify operations.
+type eventsFlag struct {
+	mask fsnotify.Op
+}
+
+func (e *eventsFlag) String() string {
+	if e.mask == 0 {
+		return ""
+	}
+	return strings.ReplaceAll(strings.ToLower(e.mask.String()), "|", ",")
+}
+
+func (e *eventsFlag) Set(value string) error {
+	var mask fsnotify.Op
+	for _, name := range strings.Split(value, ",") {
+		op, ok := eventNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return fmt.Errorf("unknown event %q", name)
+		}
+		mask |= op
+	}
+	e.mask = mask
+	return nil
+}
+
+// durationRules is a repeatable flag holding a default duration and
+// per-pattern overrides, given as "5s" or "data/**=5s".
+type durationRules struct {
+	def   time.Duration
+	rules []durationRule
+}
+
+type durationRule struct {
+	pattern string
+	d       time.Duration
+}
+
+func (r *durationRules) String() string {
+	if r == nil {
+		return ""
+	}
+	s := []string{r.def.String()}
+	for _, rule := range r.rules {
+		s = append(s, rule.pattern+"="+rule.d.String())
+	}
+	return strings.Join(s, ",")
+}
+
+func (r *durationRules) Set(value string) error {
+	pattern, value := "", value
+	if i := strings.LastIndex(value, "="); i >= 0 {
+		pattern, value = value[:i], value[i+1:]
+		if pattern == "" {
+			return errors.New("empty pattern")
+		}
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	if d < 0 {
+		return errors.New("duration must not be negative")
+	}
+	if pattern == "" {
+		r.def = d
+	} else {
+		r.rules = append(r.rules, durationRule{pattern: pattern, d: d})
+	}
+	return nil
+}
+
+// signalFlag is --signal, accepting "SIGHUP", "hup" or a number.
+type signalFlag struct {
+	sig syscall.Signal
+}
+
+func (f *signalFlag) String() string {
+	for name, sig := range signalNames {
+		if sig == f.sig {
+			return "SIG" + name
+		}
+	}
+	return strconv.Itoa(int(f.sig))
+}
+
+func (f *signalFlag) Set(value string) error {
+	name := strings.TrimPrefix(strings.ToUpper(value), "SIG")
+	if sig, ok := signalNames[name]; ok {
+		f.sig = sig
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("unknown signal %q", value)
+	}
+	f.sig = syscall.Signal(n)
+	return nil
+}
+
+// stdinFilesFlag is --stdin-files, given bare for newline separated
+// paths or as --stdin-files=nul.
+type stdinFilesFlag struct {
+	enabled bool
+	nul     bool
+}
+
+func (f *stdinFilesFlag) IsBoolFlag() bool { return true }
+
+func (f *stdinFilesFlag) String() string {
+	switch {
+	case f == nil || !f.enabled:
+		return ""
+	case f.nul:
+		return "nul"
+	}
+	return "true"
+}
+
+func (f *stdinFilesFlag) Set(value string) error {
+	switch strings.ToLower(value) {
+	case "true", "newline":
+		f.enabled, f.nul = true, false
+	case "nul", "0":
+		f.enabled, f.nul = true, true
+	case "false":
+		f.enabled = false
+	default:
+		return fmt.Errorf("expected nul or newline, got %q", value)
+	}
+	return nil
+}
+
+// ioniceFlag is --ionice, a class with an optional level from 0 to 7.
+type ioniceFlag struct {
+	class int // 1 realtime, 2 best-effort, 3 idle; 0 if unset
+	level int // -1 if unset
+}
+
+var ioniceClasses = map[string]int{"realtime": 1, "best-effort": 2, "idle": 3}
+
+func (f *ioniceFlag) String() string {
+	if f == nil || f.class == 0 {
+		return ""
+	}
+	for name, class := range ioniceClasses {
+		if class == f.class && f.level >= 0 {
+			return fmt.Sprintf("%s:%d", name, f.level)
+		} else if class == f.class {
+			return name
+		}
+	}
+	return ""
+}
+
+func (f *ioniceFlag) Set(value string) error {
+	if runtime.GOOS != "linux" {
+		return errors.New("only supported on Linux")
+	}
+	name, level, hasLevel := strings.Cut(strings.ToLower(value), ":")
+	class, ok := ioniceClasses[name]
+	if !ok {
+		return fmt.Errorf("unknown class %q, expected idle, best-effort or realtime", name)
+	}
+	f.class, f.level = class, -1
+	if hasLevel {
+		n, err := strconv.Atoi(level)
+		if err != nil || n < 0 || n > 7 {
+			return fmt.Errorf("level must be between 0 and 7, got %q", level)
+		}
+		f.level = n
+	}
+	return nil
+}
+
+// cgroupLimits are the --memory-limit and --cpu-limit resources.
+type cgroupLimits struct {
+	memory byteSize
+	cpu    float64 // CPUs
+}
+
+func (l cgroupLimits) set() bool { return l.memory > 0 || l.cpu > 0 }
+
+// byteSize is a flag.Value for sizes like "512M" or "2G".
+type byteSize int64
+
+func (b *byteSize) String() string {
+	if b == nil || *b == 0 {
+		return ""
+	}
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(value string) error {
+	units := map[string]int64{"": 1, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}
+	v := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(value), "B"), "I")
+	i := strings.LastIndexAny(v, "0123456789.") + 1
+	unit, ok := units[v[i:]]
+	n, err := strconv.ParseFloat(v[:i], 64)
+	if !ok || err != nil || n <= 0 {
+		return fmt.Errorf("invalid size %q, expected e.g. 512M or 2G", value)
+	}
+	*b = byteSize(n * float64(unit))
+	return nil
+}
+
+// clearFlag is -c, clearing the screen before each run; -c=all also
+// clears the scrollback.
+type clearFlag struct {
+	screen     bool
+	scrollback bool
+}
+
+func (f *clearFlag) IsBoolFlag() bool { return true }
+
+func (f *clearFlag) String() string {
+	switch {
+	case f == nil || !f.screen:
+		return ""
+	case f.scrollback:
+		return "all"
+	}
+	return "true"
+}
+
+func (f *clearFlag) Set(value string) error {
+	switch strings.ToLower(value) {
+	case "true", "screen":
+		f.screen, f.scrollback = true, false
+	case "all", "scrollback":
+		f.screen, f.scrollback = true, true
+	case "false":
+		f.screen, f.scrollback = false, false
+	default:
+		return fmt.Errorf("expected -c, -c=all or -c=false, not %q", value)
+	}
+	return nil
+}
+
+// ruleSpec is a command and the pattern of the changes it runs for.
+type ruleSpec struct {
+	pattern string
+	command string
+	argv    []string // for --no-shell
+
+	// Set for the named rules of a --config file
+	name  string
+	paths []string // empty matches every change
+	lock  string   // rules with the same lock never run at once
+	opts  *options
+}
+
+// ruleList is the repeatable --rule 'PATTERN=COMMAND'.
+type ruleList []ruleSpec
+
+func (l *ruleList) String() string {
+	var s []string
+	for _, r := range *l {
+		s = append(s, r.pattern+"="+r.command)
+	}
+	return strings.Join(s, ",")
+}
+
+func (l *ruleList) Set(value string) error {
+	pattern, command, _ := strings.Cut(value, "=")
+	if pattern == "" || strings.TrimSpace(command) == "" {
+		return errors.New("expected PATTERN=COMMAND")
+	}
+	*l = append(*l, ruleSpec{pattern: pattern, command: command, argv: strings.Fields(command)})
+	return nil
+}
+
+// newOptions returns the options before any flags are applied.
+func newOptions() *options {
+	return &options{
+		events:      eventsFlag{mask: defaultEvents},
+		logFormat:   "text",
+		timeFormat:  "clock",
+		logMaxSize:  10 << 20,
+		logKeep:     5,
+		liveReload:  portFlag{def: defaultLiveReloadPort},
+		serveAddr:   defaultServeAddr,
+		mqttTopic:   "on_change/{event}",
+		interval:    defaultRemoteInterval,
+		debounce:    durationRules{def: defaultDebounce},
+		throttle:    durationRules{def: defaultThrottle},
+		signal:      signalFlag{syscall.SIGTERM},
+		killTimeout: defaultKillTimeout,
+		jobs:        1,
+
+		chatTemplate:          defaultChatTemplate,
+		chatRecoveredTemplate: defaultChatRecoveredTemplate,
+	}
+}
+
+// parseArgs parses "[flags] <path>... -- <command>". Flags may be mixed
+// freely with the paths, everything after the first "--" is the command.
+func parseArgs(name string, args []string, stderr io.Writer) (*options, error) {
+	// Personal defaults come first, so the command line overrides them
+	var defaults []string
+	if env := os.Getenv("ON_CHANGE_OPTS"); env != "" {
+		var err error
+		if defaults, err = splitWords(env); err != nil {
+			return nil, fmt.Errorf("ON_CHANGE_OPTS: %w", err)
+		}
+	}
+	o, err := parseCommandLine(name, args, defaults, stderr)
+	if err == nil && o.emitConfig && len(defaults) > 0 {
+		// The generated file is shared, so it leaves the personal defaults out
+		if own, err := parseCommandLine(name, args, nil, stderr); err == nil && own.emitConfig {
+			return own, nil
+		}
+	}
+	return o, err
+}
+
+// parseCommandLine parses args after the flags in defaults.
+func parseCommandLine(name string, args, defaults []string, stderr io.Writer) (*options, error) {
+	o := newOptions()
+
+	var profile string
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	o.define(fs)
+	fs.StringVar(&o.configFile, "config", "", "read flags and named rules from this YAML file; flags given on the command line take precedence")
+	fs.StringVar(&profile, "profile", "", "with a config file, only run the rules of this profile")
+	fs.BoolVar(&o.emitConfig, "emit-config", false, "print the flags, paths and command as a config file for --config, instead of running")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: %s [flags] <file1> [file2 ...] -- <command>\n", name)
+		fmt.Fprintf(stderr, "       %s config validate [file]\n", name)
+		fmt.Fprintf(stderr, "       %s ctl status|trigger|pause|resume|list|add PATH|rm PATH\n", name)
+		fmt.Fprintf(stderr, "       %s serve <dir> [flags] [--on '*.html,*.css'] -- <command>\n", name)
+		fmt.Fprintf(stderr, "Example: %s main.c utils.c -- 'make'\n", name)
+		fmt.Fprintf(stderr, "Example: %s *.go -- 'go build'\n", name)
+		fmt.Fprintf(stderr, "Example: %s -r src/ -- 'make'\n", name)
+		fmt.Fprintf(stderr, "Example: %s 'src/**/*.ts' -- 'tsc'\n", name)
+		fmt.Fprintf(stderr, "Example: %s -r . --restart -- './server'\n", name)
+		fmt.Fprintf(stderr, "Example: find . -name '*.c' | %s - -- 'make'\n", name)
+		fmt.Fprintf(stderr, "Example: %s '*.md' -- 'pandoc {file} -o {base}.html'\n", name)
+		fmt.Fprintf(stderr, "Example: %s -r . --rule '*.go=go build' --rule '*.proto=make proto'\n", name)
+		fmt.Fprintf(stderr, "Example: %s --config onchange.yml --profile test\n", name)
+		fmt.Fprintf(stderr, "Example: %s -r src/ --docker app --cwd /app -- 'make test'\n", name)
+		fmt.Fprintf(stderr, "Example: %s https://example.com/schema.json --interval 30s -- 'make codegen'\n", name)
+		fmt.Fprintf(stderr, "\nFlags:\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(stderr, "\nPlaceholders in the command:\n")
+		fmt.Fprintf(stderr, "  {}       all changed paths\n")
+		fmt.Fprintf(stderr, "  {file}   the most recently changed path\n")
+		fmt.Fprintf(stderr, "  {dir}    its directory\n")
+		fmt.Fprintf(stderr, "  {base}   its base name without extension\n")
+		fmt.Fprintf(stderr, "  {event}  what happened to it: create, write, remove, rename or chmod\n")
+		fmt.Fprintf(stderr, "\nThe command's environment has ON_CHANGE_FILE, ON_CHANGE_FILES (newline separated),\n")
+		fmt.Fprintf(stderr, "ON_CHANGE_EVENT and ON_CHANGE_TIMESTAMP (RFC 3339) set accordingly.\n")
+		fmt.Fprintf(stderr, "\nFlags in ON_CHANGE_OPTS, e.g. ON_CHANGE_OPTS='--debounce 300ms --hash', are applied\nbefore the command line's.\n")
+		fmt.Fprintf(stderr, "\nWithout paths or a command, .onchange.yml in the working directory or the nearest parent\nis used as --config, from its directory.\n")
+	}
+
+	// Without "--" everything is flags and paths, which is only enough
+	// when --rule gives the commands.
+	separatorIndex := len(args)
+	for i, arg := range args {
+		if arg == "--" {
+			separatorIndex = i
+			break
+		}
+	}
+
+	if len(defaults) > 0 {
+		if err := fs.Parse(defaults); err != nil {
+			return nil, fmt.Errorf("ON_CHANGE_OPTS: %w", err)
+		}
+		if fs.NArg() > 0 {
+			return nil, fmt.Errorf("ON_CHANGE_OPTS: only flags are allowed, not %q", fs.Arg(0))
+		}
+	}
+
+	// flag stops at the first non-flag argument, so keep feeding it the
+	// remainder to allow flags after (or between) the paths.
+	rest := args[:separatorIndex]
+	for {
+		if err := fs.Parse(rest); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		o.paths = append(o.paths, fs.Arg(0))
+		rest = fs.Args()[1:]
+	}
+	if separatorIndex < len(args) {
+		o.argv = args[separatorIndex+1:]
+	}
+	// Appending --emit-config to a working command line is the natural
+	// way to use it
+	if n := len(o.argv); n > 0 && o.argv[n-1] == "--emit-config" {
+		o.argv, o.emitConfig = o.argv[:n-1], true
+	}
+	o.command = strings.Join(o.argv, " ")
+	if o.configFile == "" && !o.emitConfig && len(o.paths) == 0 && len(o.remotes) == 0 && o.command == "" && o.commandFile == "" && len(o.rules) == 0 {
+		// Like make, fall back to the project's config, run from its
+		// directory
+		file, err := findConfig()
+		if err != nil {
+			return nil, err
+		}
+		if file != "" {
+			if err := os.Chdir(filepath.Dir(file)); err != nil {
+				return nil, err
+			}
+			o.configFile = file
+		}
+	}
+	if o.emitConfig {
+		if o.configFile != "" {
+			return nil, errors.New("--emit-config cannot be combined with --config")
+		}
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name != "emit-config" {
+				o.given = append(o.given, f)
+			}
+		})
+		return o, nil
+	}
+	if o.configFile != "" {
+		if err := o.applyConfig(fs, o.configFile, profile); err != nil {
+			return nil, err
+		}
+	} else if profile != "" {
+		return nil, errors.New("--profile needs a config file")
+	}
+	if o.attrib {
+		o.events.mask |= fsnotify.Chmod
+	}
+
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	if len(o.paths) == 0 && len(o.remotes) == 0 || o.command == "" && o.commandFile == "" && len(o.rules) == 0 {
+		fs.Usage()
+		return nil, errors.New("must specify files before -- and command after --")
+	}
+	return o, nil
+}
+
+// define registers the flags on fs, with the current values of o as
+// their defaults.
+func (o *options) define(fs *flag.FlagSet) {
+	fs.BoolVar(&o.recursive, "r", o.recursive, "watch directories recursively, including subdirectories created later")
+	fs.IntVar(&o.maxDepth, "max-depth", o.maxDepth, "with -r, only react to paths at most this many levels below each directory (0 means no limit)")
+	fs.BoolVar(&o.noGitignore, "no-gitignore", o.noGitignore, "do not skip paths ignored by .gitignore and .git/info/exclude when watching recursively")
+	fs.BoolVar(&o.hidden, "hidden", o.hidden, "include dotfiles and dot directories (.git, .cache, ...) when watching recursively")
+	fs.Var(&o.excludes, "exclude", "glob of paths to ignore, e.g. '*.tmp' or 'dist/**' (repeatable)")
+	fs.BoolVar(&o.noDefaultIgnores, "no-default-ignores", o.noDefaultIgnores, "also react to editor swap, backup and lock files (*.swp, *~, .#*, ...)")
+	fs.Var(&o.exts, "ext", "only react to files with these extensions, e.g. 'go,mod,proto' (repeatable)")
+	fs.Var(&o.filters, "filter", "only react to paths matching this regular expression (repeatable)")
+	fs.Var(&o.ignoreRegexes, "ignore-regex", "ignore paths matching this regular expression (repeatable)")
+	fs.Var(&o.poll, "poll", "stat files every interval instead of using inotify/kqueue, for NFS, SMB and Docker bind mounts (--poll or --poll=2s)")
+	fs.IntVar(&o.maxWatches, "max-watches", o.maxWatches, "fail instead of starting when more than this many paths need a watch (0 means no limit)")
+	fs.BoolVar(&o.followSymlinks, "follow-symlinks", o.followSymlinks, "watch the targets of symlinks and re-resolve them when the link is replaced")
+	fs.BoolVar(&o.hash, "hash", o.hash, "only run when the content of a changed file actually differs")
+	fs.Var(&o.events, "events", "comma separated kinds of change that trigger the command: create, write, remove, rename, chmod or all")
+	fs.BoolVar(&o.nulPaths, "0", o.nulPaths, "paths read from stdin (-) are NUL separated, as produced by find -print0")
+	fs.BoolVar(&o.attrib, "attrib", o.attrib, "also react to permission and attribute changes (same as adding chmod to --events)")
+	fs.Var(&o.remotes, "remote", "poll a file or directory on another machine over ssh, as [user@]host:/path or [user@][::1]:/path (repeatable)")
+	fs.DurationVar(&o.interval, "interval", o.interval, "how often URLs and --remote sources are checked")
+	fs.StringVar(&o.control, "control", o.control, "listen on this Unix socket for 'add PATH', 'remove PATH', 'list', 'pause' and 'resume' commands")
+	fs.Var(&o.debounce, "debounce", "how long changes must settle before running, as '200ms' or per pattern as 'data/**=5s' (repeatable)")
+	fs.Var(&o.throttle, "throttle", "minimum time between runs, as '2s' or per pattern as 'deploy/**=30s'; changes in between are run together afterwards (repeatable)")
+	fs.BoolVar(&o.restart, "restart", o.restart, "for long-running commands: stop the running instance on each change and start a new one")
+	fs.Var(&o.signal, "signal", "signal sent to stop the command, e.g. SIGINT; with --restart, a signal like SIGHUP or SIGUSR2 is delivered to the running instance instead of restarting it")
+	fs.DurationVar(&o.killTimeout, "kill-timeout", o.killTimeout, "how long a stopped command may take to exit before it is killed with SIGKILL")
+	fs.BoolVar(&o.noShell, "no-shell", o.noShell, "run the command's words directly instead of through 'sh -c'; a lone {} argument expands to one argument per changed path")
+	fs.StringVar(&o.shell, "shell", o.shell, "shell running the command, e.g. bash, zsh, fish or pwsh (default $SHELL, or sh)")
+	fs.BoolVar(&o.perFile, "per-file", o.perFile, "run the command once for each changed file instead of once per batch of changes")
+	fs.BoolVar(&o.argsAppend, "args-append", o.argsAppend, "append the changed paths to the command as arguments, like xargs")
+	fs.Var(&o.stdinFiles, "stdin-files", "write the changed paths to the command's stdin, one per line, or NUL terminated with --stdin-files=nul")
+	fs.IntVar(&o.jobs, "jobs", o.jobs, "with --per-file, run up to this many commands at once")
+	fs.BoolVar(&o.cancelOnChange, "cancel-on-change", o.cancelOnChange, "stop a run that is still going when files change again, and start over")
+	fs.DurationVar(&o.timeout, "timeout", o.timeout, "stop a run that takes longer than this, e.g. 2m (0 means no limit)")
+	fs.IntVar(&o.retries, "retries", o.retries, "with --timeout, retry a run that timed out up to this many times")
+	fs.StringVar(&o.onSuccess, "on-success", o.onSuccess, "command to run after the command succeeds")
+	fs.StringVar(&o.onFailure, "on-failure", o.onFailure, "command to run after the command fails or times out; ON_CHANGE_EXIT_CODE holds its exit code")
+	fs.StringVar(&o.before, "before", o.before, "command to run before every run of the command")
+	fs.StringVar(&o.after, "after", o.after, "command to run after every run of the command, even when it failed or timed out")
+	fs.StringVar(&o.cwd, "cwd", o.cwd, "run the command in this directory; changed paths are then passed as absolute paths")
+	fs.Var(&o.envs, "env", "set KEY=VALUE in the command's environment (repeatable)")
+	fs.Var(&o.envFiles, "env-file", "load KEY=VALUE lines from a .env file into the command's environment (repeatable)")
+	fs.BoolVar(&o.cleanEnv, "clean-env", o.cleanEnv, "start the command with only PATH from the environment, plus --env and --env-file")
+	fs.StringVar(&o.user, "user", o.user, "run the command as this user, by name or id (requires privileges)")
+	fs.StringVar(&o.group, "group", o.group, "run the command with this group, by name or id (default the --user's group)")
+	fs.StringVar(&o.docker, "docker", o.docker, "run the command with 'docker exec' in this container; --cwd is then a directory in the container")
+	fs.BoolVar(&o.exitStatus, "exit-status", o.exitStatus, "exit with the status of the last finished run instead of 0")
+	fs.BoolVar(&o.once, "once", o.once, "skip the initial run, run the command on the first change and exit")
+	fs.BoolVar(&o.exitOnChange, "exit-on-change", o.exitOnChange, "do not run anything, exit with status 2 on the first change (for shell loops and CI)")
+	fs.BoolVar(&o.noInitial, "no-initial", o.noInitial, "do not run the command at startup, only on changes")
+	fs.StringVar(&o.initialIfStale, "initial-if-stale", o.initialIfStale, "only run at startup if this file is missing or older than a watched file, like make")
+	fs.BoolVar(&o.dryRun, "dry-run", o.dryRun, "print the command that would run, with its arguments and environment, instead of running it")
+	fs.IntVar(&o.nice, "nice", o.nice, "run the command with this niceness, e.g. 10 for lower CPU priority")
+	fs.Var(&o.ionice, "ionice", "Linux only: run the command with this IO scheduling class, idle, best-effort or realtime, optionally with a level as best-effort:7")
+	fs.Var(&o.limits.memory, "memory-limit", "Linux only: limit the command's memory, e.g. 512M or 2G, using a transient cgroup v2")
+	fs.Float64Var(&o.limits.cpu, "cpu-limit", o.limits.cpu, "Linux only: limit the command to this many CPUs, e.g. 1.5, using a transient cgroup v2")
+	fs.BoolVar(&o.pty, "pty", o.pty, "run the command in a pseudo-terminal, so it keeps its colors and progress bars")
+	fs.BoolVar(&o.forwardStdin, "forward-stdin", o.forwardStdin, "pass what is typed on stdin to the running command, e.g. a dev server with a console (disables the keys like r to re-run)")
+	fs.Var(&o.rules, "rule", "run a command for changes matching a pattern, as '*.proto=make proto'; the command after -- is then optional (repeatable)")
+	fs.StringVar(&o.commandFile, "command-file", o.commandFile, "run this script instead of a command after --, with the changed files as its arguments; it is watched too and re-read on every run")
+	fs.BoolVar(&o.quiet, "quiet", o.quiet, "print nothing but the command's own output, no banner or status lines")
+	fs.BoolVar(&o.verbose, "verbose", o.verbose, "log every file event and why it did or did not trigger the command to stderr")
+	fs.StringVar(&o.logFormat, "log-format", o.logFormat, "text, or json for one JSON object per line on stderr instead of status messages")
+	fs.Var(&o.clear, "c", "clear the screen before each run; -c=all also clears the scrollback")
+	fs.Var(&o.clear, "clear", "same as -c")
+	fs.StringVar(&o.timeFormat, "time-format", o.timeFormat, "how times are shown: clock, rfc3339, unix, relative (+2m13s since start) or a Go layout like '15:04:05.000'")
+	fs.StringVar(&o.prefix, "prefix", o.prefix, "prepend this to every line of the command's output, e.g. '[build] '; config rules default to their name")
+	fs.BoolVar(&o.notify, "notify", o.notify, "show a desktop notification with the outcome and duration when a run finishes")
+	fs.BoolVar(&o.bell, "bell", o.bell, "ring the terminal bell when the command fails, which most terminals and tmux turn into an urgency hint")
+	fs.BoolVar(&o.status, "status", o.status, "while the command runs, show how long it has been running on a status line")
+	fs.StringVar(&o.logFile, "log-file", o.logFile, "also append on_change's messages (changes, runs and their results) to this file, with timestamps")
+	fs.Var(&o.logMaxSize, "log-max-size", "rotate the --log-file once it reaches this size, e.g. 10M")
+	fs.IntVar(&o.logKeep, "log-keep", o.logKeep, "how many rotated --log-files to keep as FILE.1, FILE.2, ...")
+	fs.BoolVar(&o.tui, "tui", o.tui, "full-screen dashboard with the watched paths, recent events, run history and output; keys r re-run, p pause, q quit")
+	fs.BoolVar(&o.runOnResume, "run-on-resume", o.runOnResume, "when watching is resumed after a pause (p, SIGUSR2 or the control socket), run once for the changes missed meanwhile")
+	fs.BoolVar(&o.title, "title", o.title, "show running, FAILED or idle in the terminal's window title, or tmux's with set-titles on")
+	fs.Var(&o.collapse, "collapse", "show the command's output only if it fails; --collapse=N also shows the last N lines when it succeeds")
+	fs.Var(&o.liveReload, "livereload", fmt.Sprintf("after each successful run, reload the browsers connected with a LiveReload extension or /livereload.js (--livereload or --livereload=PORT, default %d)", defaultLiveReloadPort))
+	fs.StringVar(&o.serve, "serve", o.serve, "serve this directory over HTTP, reloading its HTML pages after each successful run")
+	fs.StringVar(&o.serveAddr, "serve-addr", o.serveAddr, "address for --serve to listen on")
+	fs.StringVar(&o.httpAddr, "http", o.httpAddr, "serve an HTTP API on this address, e.g. localhost:8787: GET /status and /metrics, POST /run, /pause and /resume")
+	fs.Var(&o.webhooks, "webhook", "POST a JSON payload with the files, command, exit code, duration and hostname to this URL when a run starts and finishes (repeatable)")
+	fs.Var(&o.chat, "chat", "post to this Slack or Discord incoming webhook when a run fails (repeatable)")
+	fs.BoolVar(&o.chatRecovered, "chat-recovered", o.chatRecovered, "also post to --chat when a run succeeds after a failed one")
+	fs.StringVar(&o.chatTemplate, "chat-template", o.chatTemplate, "the --chat message on failure, a Go template with {{.Command}}, {{.Rule}}, {{.ExitCode}}, {{.Duration}}, {{.Files}}, {{.Hostname}} and {{.Time}}")
+	fs.StringVar(&o.chatRecoveredTemplate, "chat-recovered-template", o.chatRecoveredTemplate, "the --chat message on recovery, with the fields of --chat-template")
+	fs.StringVar(&o.wsAddr, "ws", o.wsAddr, "stream changes, runs, results and the command's output as JSON to WebSocket clients on this address, e.g. localhost:9000")
+	fs.Var(&o.origins, "allow-origin", "let web pages of this origin, as http://localhost:3000, or * for any, use --ws and the POSTs of --http (repeatable)")
+	fs.StringVar(&o.grpcAddr, "grpc", o.grpcAddr, "serve the gRPC API of onchange.proto on this address: Watch, Trigger, Pause, StreamEvents and StreamOutput")
+	fs.BoolVar(&o.journal, "journal", o.journal, "send on_change's own messages to the systemd journal with their details as ON_CHANGE_* fields, instead of printing them")
+	fs.BoolVar(&o.daemon, "daemon", o.daemon, "detach and keep watching in the background once set up; output goes to the --log-file or is dropped, SIGTERM stops it and SIGHUP reopens the log file")
+	fs.StringVar(&o.pidfile, "pidfile", o.pidfile, "write on_change's pid to this file while it watches, refusing to start if the pid in it still runs")
+	fs.StringVar(&o.mqtt, "mqtt", o.mqtt, "publish changes and the results of runs as JSON to this MQTT broker, as mqtt://[user:password@]host[:port] or mqtts://")
+	fs.StringVar(&o.mqttTopic, "mqtt-topic", o.mqttTopic, "the topic for --mqtt; {event} becomes change or exit, {hostname} this machine's name")
+}
+
+// validate checks combinations of flags that cannot work together.
+func (o *options) validate() error {
+	if o.interval <= 0 {
+		return errors.New("--interval must be positive")
+	}
+	if o.jobs < 1 {
+		return errors.New("--jobs must be at least 1")
+	}
+	if o.jobs > 1 && o.restart {
+		return errors.New("--jobs cannot be combined with --restart")
+	}
+	if o.timeout < 0 || o.retries < 0 {
+		return errors.New("--timeout and --retries must not be negative")
+	}
+	if o.limits.cpu < 0 {
+		return errors.New("--cpu-limit must not be negative")
+	}
+	if o.nice < -20 || o.nice > 19 {
+		return errors.New("--nice must be between -20 and 19")
+	}
+	if o.forwardStdin && (o.pty || o.stdinFiles.enabled || slices.Contains(o.paths, "-")) {
+		return errors.New("--forward-stdin cannot be combined with --pty, --stdin-files or reading paths from stdin")
+	}
+	if o.command != "" && o.commandFile != "" {
+		return errors.New("give either --command-file or a command after --, not both")
+	}
+	if o.once && o.exitOnChange {
+		return errors.New("--once and --exit-on-change cannot be combined")
+	}
+	if o.logFormat != "text" && o.logFormat != "json" {
+		return errors.New("--log-format must be text or json")
+	}
+	if o.collapse.enabled && o.restart {
+		return errors.New("--collapse cannot be combined with --restart")
+	}
+	if _, err := parseChatTemplate(o.chatTemplate); err != nil {
+		return fmt.Errorf("--chat-template: %v", err)
+	}
+	if _, err := parseChatTemplate(o.chatRecoveredTemplate); err != nil {
+		return fmt.Errorf("--chat-recovered-template: %v", err)
+	}
+	if o.daemon && (o.tui || o.forwardStdin) {
+		return errors.New("--daemon cannot be combined with --tui or --forward-stdin")
+	}
+	if o.tui && (o.logFormat == "json" || o.forwardStdin) {
+		return errors.New("--tui cannot be combined with --log-format json or --forward-stdin")
+	}
+	if o.logKeep < 0 {
+		return errors.New("--log-keep must not be negative")
+	}
+	if o.killTimeout < 0 {
+		return errors.New("--kill-timeout must not be negative")
+	}
+	return nil
+}
+
+// clone returns a copy of o whose repeatable flags can be added to
+// without changing o.
+func (o *options) clone() *options {
+	c := *o
+	c.excludes = slices.Clip(c.excludes)
+	c.webhooks = slices.Clip(c.webhooks)
+	c.origins = slices.Clip(c.origins)
+	c.chat = slices.Clip(c.chat)
+	c.exts = slices.Clip(c.exts)
+	c.filters = slices.Clip(c.filters)
+	c.ignoreRegexes = slices.Clip(c.ignoreRegexes)
+	c.remotes = slices.Clip(c.remotes)
+	c.debounce.rules = slices.Clip(c.debounce.rules)
+	c.throttle.rules = slices.Clip(c.throttle.rules)
+	c.envs = slices.Clip(c.envs)
+	c.envFiles = slices.Clip(c.envFiles)
+	c.rules = slices.Clip(c.rules)
+	c.paths = slices.Clip(c.paths)
+	return &c
+}
+
+// readStdinPaths replaces a "-" path with the newline (or NUL, with -0)
+// separated paths read from stdin.
+func readStdinPaths(paths []string, stdin io.Reader, nul bool) ([]string, error) {
+	var out []string
+	read := false
+	for _, p := range paths {
+		if p != "-" {
+			out = append(out, p)
+			continue
+		}
+		if read {
+			continue
+		}
+		read = true
+
+		scanner := bufio.NewScanner(stdin)
+		if nul {
+			scanner.Split(scanNul)
+		}
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !nul {
+				line = strings.TrimRight(line, "\r")
+			}
+			if line != "" {
+				out = append(out, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("reading paths from stdin: %w", err)
+		}
+	}
+	return out, nil
+}
+
+// scanNul is a bufio.SplitFunc for NUL terminated records, as produced
+// by find -print0.
+func scanNul(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
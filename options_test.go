@@ -0,0 +1,111 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDurationRules(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   durationRules
+		str    string
+	}{
+		{nil, durationRules{def: time.Second}, "1s"},
+		{[]string{"5s"}, durationRules{def: 5 * time.Second}, "5s"},
+		{
+			[]string{"data/**=5s", "100ms"},
+			durationRules{def: 100 * time.Millisecond, rules: []durationRule{{"data/**", 5 * time.Second}}},
+			"100ms,data/**=5s",
+		},
+		{
+			// The last "=" separates, patterns may contain one
+			[]string{"a=b/*.go=2m", "*.md=0s"},
+			durationRules{def: time.Second, rules: []durationRule{{"a=b/*.go", 2 * time.Minute}, {"*.md", 0}}},
+			"1s,a=b/*.go=2m0s,*.md=0s",
+		},
+	}
+	for _, tt := range tests {
+		r := durationRules{def: time.Second}
+		for _, v := range tt.values {
+			if err := r.Set(v); err != nil {
+				t.Fatalf("Set(%q): %v", v, err)
+			}
+		}
+		if !reflect.DeepEqual(r, tt.want) {
+			t.Errorf("after %q: got %+v, want %+v", tt.values, r, tt.want)
+		}
+		if got := r.String(); got != tt.str {
+			t.Errorf("after %q: String() = %q, want %q", tt.values, got, tt.str)
+		}
+	}
+
+	for _, bad := range []string{"", "fast", "=5s", "-1s", "*.go=", "*.go=soon"} {
+		var r durationRules
+		if err := r.Set(bad); err == nil {
+			t.Errorf("Set(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestByteSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want byteSize
+	}{
+		{"1", 1},
+		{"512", 512},
+		{"1K", 1 << 10},
+		{"1k", 1 << 10},
+		{"512M", 512 << 20},
+		{"10MB", 10 << 20},
+		{"2G", 2 << 30},
+		{"2GiB", 2 << 30},
+		{"1.5G", 3 << 29},
+		{"1T", 1 << 40},
+	}
+	for _, tt := range tests {
+		var b byteSize
+		if err := b.Set(tt.in); err != nil {
+			t.Errorf("Set(%q): %v", tt.in, err)
+			continue
+		}
+		if b != tt.want {
+			t.Errorf("Set(%q) = %d, want %d", tt.in, b, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "M", "0", "-1M", "10X", "ten", "1.2.3M"} {
+		var b byteSize
+		if err := b.Set(bad); err == nil {
+			t.Errorf("Set(%q) = %d, want an error", bad, b)
+		}
+	}
+}
+
+func TestReadStdinPaths(t *testing.T) {
+	tests := []struct {
+		paths []string
+		stdin string
+		nul   bool
+		want  []string
+	}{
+		{[]string{"a.go"}, "ignored\n", false, []string{"a.go"}},
+		{[]string{"-"}, "a.go\nb c.go\r\n\nd.go", false, []string{"a.go", "b c.go", "d.go"}},
+		{[]string{"x", "-", "y", "-"}, "a.go\n", false, []string{"x", "a.go", "y"}},
+		{[]string{"-"}, "a.go\x00with\nnewline\x00\x00last", true, []string{"a.go", "with\nnewline", "last"}},
+		{[]string{"-"}, "trailing \r\x00", true, []string{"trailing \r"}},
+	}
+	for _, tt := range tests {
+		got, err := readStdinPaths(tt.paths, strings.NewReader(tt.stdin), tt.nul)
+		if err != nil {
+			t.Errorf("readStdinPaths(%q, %q): %v", tt.paths, tt.stdin, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("readStdinPaths(%q, %q, %v) = %q, want %q", tt.paths, tt.stdin, tt.nul, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	quiet   bool // suppresses on_change's own messages, for --quiet
+	verbose bool // prints debugf messages, for --verbose
+	logJSON bool // messages are JSON records on stderr, for --log-format json
+	clearOn clearFlag
+	color   bool // stdout is a terminal and NO_COLOR is not set
+	titleOn bool // --title and stdout is a terminal
+
+	timeFormat = "clock" // --time-format
+	startTime  = time.Now()
+
+	logMu       sync.Mutex // serializes writes to the terminal
+	statusShown bool       // a --status line is on screen, guarded by logMu
+)
+
+// fields are the details of a logged event, for --log-format json.
+type fields map[string]any
+
+// logf prints one of on_change's own messages, as opposed to the
+// command's output.
+func logf(format string, args ...any) {
+	logEvent("message", nil, format, args...)
+}
+
+// printText prints to stdout, in place of the status line if one is
+// shown.
+func printText(format string, args ...any) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	eraseStatus()
+	fmt.Printf(format, args...)
+}
+
+// setStatus replaces the status line, for --status; "" removes it.
+func setStatus(text string) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	eraseStatus()
+	if text != "" {
+		os.Stdout.WriteString(text)
+		statusShown = true
+	}
+}
+
+// eraseStatus removes the status line. logMu must be held.
+func eraseStatus() {
+	if statusShown {
+		os.Stdout.WriteString("\r\033[K")
+		statusShown = false
+	}
+}
+
+// console writes a command's output to our stdout or stderr, taking
+// turns with on_change's own messages and the status line.
+type console struct {
+	f *os.File
+}
+
+func (c console) Write(b []byte) (int, error) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	if screen != nil {
+		screen.write(b)
+		return len(b), nil
+	}
+	eraseStatus()
+	return c.f.Write(b)
+}
+
+// warnf prints a warning or error on stderr, or into the dashboard
+// while it is shown, the way a command's output is.
+func warnf(format string, args ...any) {
+	fmt.Fprintf(console{os.Stderr}, format, args...)
+}
+
+// logEvent reports one of the events worth a structured record: as the
+// message, if there is one, or with --log-format json as a record of
+// the given kind with f. Plain messages without text, like blank lines,
+// are only printed.
+func logEvent(kind string, f fields, format string, args ...any) {
+	text := fmt.Sprintf(format, args...)
+	if f == nil {
+		f = fields{}
+	}
+	msg := strings.TrimSpace(text)
+	if msg != "" {
+		f["message"] = msg
+	}
+	record := kind != "message" || msg != ""
+	if record {
+		logFile.write(kind, f)
+		eventStream.publish(kind, f)
+	}
+	if journal != nil {
+		if record {
+			priority := 6 // info
+			if code, ok := f["exit_code"].(int); ok && code != 0 {
+				priority = 3 // err
+			}
+			journal.write(kind, f, priority)
+		}
+		return
+	}
+	if d := shownDashboard(); d != nil {
+		if msg != "" {
+			d.event(kind, f, msg)
+		}
+		return
+	}
+	if quiet {
+		return
+	}
+	if !logJSON {
+		if text != "" {
+			printText("%s", text)
+		}
+		return
+	}
+	if record {
+		writeRecord(kind, f)
+	}
+}
+
+// writeRecord writes a JSON log record on a line of its own.
+func writeRecord(kind string, f fields) {
+	f["time"] = time.Now().Format(time.RFC3339Nano)
+	f["event"] = kind
+	b, err := json.Marshal(f)
+	if err != nil {
+		return
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	os.Stderr.Write(append(b, '\n'))
+}
+
+// clearBeforeRun clears the terminal for -c. Output that is not a
+// terminal is left alone.
+func clearBeforeRun() {
+	if clearOn.screen && !quiet && !logJSON && isTerminal(os.Stdout) && shownDashboard() == nil {
+		clearScreen(clearOn.scrollback)
+	}
+}
+
+func green(s string) string { return paint("32", s) }
+func red(s string) string   { return paint("31", s) }
+
+func paint(code, s string) string {
+	if !color {
+		return s
+	}
+	return "\033[" + code + "m" + s + "\033[0m"
+}
+
+// setTitle shows the state of on_change in the terminal's window title,
+// for --title.
+func setTitle(state string) {
+	if !titleOn {
+		return
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	os.Stdout.WriteString("\033]2;on_change: " + state + "\a")
+}
+
+// saveTitle saves the window title for restoreTitle, in terminals that
+// keep a stack of titles.
+func saveTitle() {
+	if titleOn {
+		os.Stdout.WriteString("\033[22;2t")
+	}
+}
+
+func restoreTitle() {
+	if titleOn {
+		os.Stdout.WriteString("\033[23;2t")
+	}
+}
+
+// ringBell rings the terminal bell, for --bell.
+func ringBell() {
+	logMu.Lock()
+	defer logMu.Unlock()
+	os.Stdout.WriteString("\a")
+}
+
+// formatTime formats t for the trigger messages according to
+// --time-format: clock, rfc3339, unix, relative to the start, or a Go
+// time layout.
+func formatTime(t time.Time) string {
+	switch timeFormat {
+	case "clock":
+		return t.Format("15:04:05")
+	case "rfc3339":
+		return t.Format(time.RFC3339)
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10)
+	case "relative":
+		return "+" + t.Sub(startTime).Round(time.Second).String()
+	}
+	return t.Format(timeFormat)
+}
+
+// formatDuration rounds d for humans: 1.2s, 340ms.
+func formatDuration(d time.Duration) string {
+	if d >= time.Second {
+		return d.Round(100 * time.Millisecond).String()
+	}
+	return d.Round(time.Millisecond).String()
+}
+
+// prefixWriter prepends a prefix to every line of a command's output,
+// for --prefix. Whole lines are written at once, so with a console the
+// output of commands running side by side does not mix within a line.
+type prefixWriter struct {
+	w       io.Writer
+	prefix  string
+	partial []byte
+}
+
+func newPrefixWriter(w io.Writer, prefix string) *prefixWriter {
+	return &prefixWriter{w: w, prefix: prefix}
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	var out []byte
+	for {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			break
+		}
+		out = append(out, p.prefix...)
+		out = append(out, p.partial...)
+		out = append(out, b[:i+1]...)
+		p.partial, b = p.partial[:0], b[i+1:]
+	}
+	p.partial = append(p.partial, b...)
+	if len(out) == 0 {
+		return n, nil
+	}
+	_, err := p.w.Write(out)
+	return n, err
+}
+
+// Flush writes an unfinished last line.
+func (p *prefixWriter) Flush() {
+	if len(p.partial) > 0 {
+		p.Write([]byte("\n"))
+	}
+}
+
+// collapsedOutput holds back a command's output until it is known
+// whether the run failed, for --collapse.
+type collapsedOutput struct {
+	stdout io.Writer
+	lines  int // shown after a successful run
+
+	mu     sync.Mutex
+	chunks []outputChunk
+}
+
+// outputChunk is a write to stdout or stderr, kept in order.
+type outputChunk struct {
+	w io.Writer
+	b []byte
+}
+
+// collapsedWriter collects the writes meant for w.
+type collapsedWriter struct {
+	o *collapsedOutput
+	w io.Writer
+}
+
+func (c collapsedWriter) Write(b []byte) (int, error) {
+	c.o.mu.Lock()
+	defer c.o.mu.Unlock()
+	c.o.chunks = append(c.o.chunks, outputChunk{c.w, bytes.Clone(b)})
+	return len(b), nil
+}
+
+// writers returns the writers standing in for stdout and stderr.
+func (o *collapsedOutput) writers(stdout, stderr io.Writer) (io.Writer, io.Writer) {
+	o.stdout = stdout
+	return collapsedWriter{o, stdout}, collapsedWriter{o, stderr}
+}
+
+// show writes all of the output, after a failed run.
+func (o *collapsedOutput) show() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, c := range o.chunks {
+		c.w.Write(c.b)
+	}
+}
+
+// tail writes the last lines of stdout and stderr together, after a
+// successful run.
+func (o *collapsedOutput) tail() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n := o.lines
+	if n == 0 {
+		return
+	}
+	var all []byte
+	for _, c := range o.chunks {
+		all = append(all, c.b...)
+	}
+	all = bytes.TrimSuffix(all, []byte("\n"))
+	if len(all) == 0 {
+		return
+	}
+	lines := bytes.Split(all, []byte("\n"))
+	lines = lines[max(0, len(lines)-n):]
+	o.stdout.Write(append(bytes.Join(lines, []byte("\n")), '\n'))
+}
+
+// debugf explains on stderr what on_change observed and decided, for
+// --verbose.
+func debugf(format string, args ...any) {
+	if !verbose {
+		return
+	}
+	if logJSON {
+		writeRecord("debug", fields{"message": fmt.Sprintf(format, args...)})
+		return
+	}
+	if d := shownDashboard(); d != nil {
+		d.event("debug", nil, "debug: "+fmt.Sprintf(format, args...))
+		return
+	}
+	if journal != nil {
+		journal.write("debug", fields{"message": fmt.Sprintf(format, args...)}, 7)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s debug: %s\n", time.Now().Format("15:04:05.000"), fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// change describes what triggered a run.
+type change struct {
+	watched []string               // everything being watched, used as the label
+	files   []string               // changed paths; the watched paths on the first run
+	last    string                 // most recently changed path
+	ops     map[string]fsnotify.Op // what happened to each changed path
+	at      time.Time              // when the change was detected
+}
+
+// initialChange is the change for the run at startup, before anything
+// actually changed.
+func initialChange(watched []string) change {
+	c := change{watched: watched, files: watched, at: time.Now()}
+	if len(watched) > 0 {
+		c.last = watched[len(watched)-1]
+	}
+	return c
+}
+
+// abs returns c with absolute paths.
+func (c change) abs() change {
+	files := make([]string, len(c.files))
+	for i, f := range c.files {
+		files[i] = absPath(f)
+	}
+	ops := make(map[string]fsnotify.Op, len(c.ops))
+	for f, op := range c.ops {
+		ops[absPath(f)] = op
+	}
+	c.files, c.ops, c.last = files, ops, absPath(c.last)
+	return c
+}
+
+// absPath is filepath.Abs, leaving URLs and remote paths alone.
+func absPath(p string) string {
+	if p == "" || isURL(p) || filepath.IsAbs(p) || isRemote(p) {
+		return p
+	}
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
+
+// event returns what happened to the most recently changed path, as
+// "write" or "create,write".
+func (c change) event() string {
+	op := c.ops[c.last]
+	if op == 0 {
+		return ""
+	}
+	return strings.ReplaceAll(strings.ToLower(op.String()), "|", ",")
+}
+
+// expand replaces the placeholders in command:
+//
+//	{}      all changed paths
+//	{file}  the most recently changed path
+//	{dir}   its directory
+//	{base}  its base name without extension
+//	{event} what happened to it, e.g. "write"
+//
+// Values are quoted with quote, for the shell running the command, when
+// they contain special characters.
+func expand(command string, c change, quote func(string) string) string {
+	return c.replacer(quote).Replace(command)
+}
+
+// expandArgs is expand for --no-shell: values are not quoted, and an
+// argument that is exactly "{}" becomes one argument per changed path.
+func expandArgs(argv []string, c change) []string {
+	r := c.replacer(func(s string) string { return s })
+	var out []string
+	for _, arg := range argv {
+		if arg == "{}" {
+			out = append(out, c.files...)
+			continue
+		}
+		out = append(out, r.Replace(arg))
+	}
+	return out
+}
+
+func (c change) replacer(quote func(string) string) *strings.Replacer {
+	files := make([]string, len(c.files))
+	for i, f := range c.files {
+		files[i] = quote(f)
+	}
+	base := filepath.Base(c.last)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+
+	return strings.NewReplacer(
+		"{}", strings.Join(files, " "),
+		"{file}", quote(c.last),
+		"{dir}", quote(filepath.Dir(c.last)),
+		"{base}", quote(base),
+		"{event}", quote(c.event()),
+	)
+}
+
+// env returns the ON_CHANGE_* variables describing c, for scripts that
+// would rather not parse placeholders.
+func (c change) env() []string {
+	return []string{
+		"ON_CHANGE_FILE=" + c.last,
+		"ON_CHANGE_FILES=" + strings.Join(c.files, "\n"),
+		"ON_CHANGE_EVENT=" + c.event(),
+		"ON_CHANGE_TIMESTAMP=" + c.at.Format(time.RFC3339),
+	}
+}
+
+var (
+	shellSafe   = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+	windowsSafe = regexp.MustCompile(`^[A-Za-z0-9_:./\\-]+$`)
+	psQuotes    = regexp.MustCompile("['\u2018\u2019\u201a\u201b]")
+)
+
+// quoterFor returns how values are quoted for shell, the --shell as
+// shellArgs takes it.
+func quoterFor(shell []string) func(string) string {
+	switch shellName(shellOf(shell)[0]) {
+	case "powershell", "pwsh":
+		return quotePowerShell
+	case "cmd":
+		return quoteCmd
+	case "fish":
+		return quoteFish
+	}
+	return quoteArg
+}
+
+// quoteArg quotes s for sh unless it only contains harmless characters.
+func quoteArg(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return shellQuote(s)
+}
+
+// quoteFish quotes s for fish, whose single quotes take \' and \\ as
+// escapes.
+func quoteFish(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// quotePowerShell quotes s for PowerShell, whose single quotes only take
+// a doubled quote as an escape. It treats the typographic single quotes
+// as quotes too.
+func quotePowerShell(s string) string {
+	if windowsSafe.MatchString(s) {
+		return s
+	}
+	return "'" + psQuotes.ReplaceAllString(s, "$0$0") + "'"
+}
+
+// quoteCmd quotes s for cmd, in whose double quotes &, |, < and the like
+// lose their meaning. Only %NAME% is still expanded, which cmd offers
+// no way to prevent.
+func quoteCmd(s string) string {
+	if windowsSafe.MatchString(s) {
+		return s
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
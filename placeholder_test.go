@@ -0,0 +1,99 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// testChange is a change of two files, the second one with a space in
+// its name.
+var testChange = change{
+	files: []string{"src/a.go", "src/my file.go"},
+	last:  "src/my file.go",
+	ops:   map[string]fsnotify.Op{"src/a.go": fsnotify.Write, "src/my file.go": fsnotify.Create | fsnotify.Write},
+}
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		command, want string
+	}{
+		{"make", "make"},
+		{"gofmt -l {}", "gofmt -l src/a.go 'src/my file.go'"},
+		{"cat {file}", "cat 'src/my file.go'"},
+		{"cd {dir} && go test", "cd src && go test"},
+		{"pandoc {file} -o {base}.html", "pandoc 'src/my file.go' -o 'my file'.html"},
+		{"echo {event}", "echo create,write"},
+		{"echo {} {}", "echo src/a.go 'src/my file.go' src/a.go 'src/my file.go'"},
+		// Only the known placeholders
+		{"awk '{print $1}' {file}", "awk '{print $1}' 'src/my file.go'"},
+	}
+	for _, tt := range tests {
+		if got := expand(tt.command, testChange, quoteArg); got != tt.want {
+			t.Errorf("expand(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestExpandArgs(t *testing.T) {
+	tests := []struct {
+		argv, want []string
+	}{
+		{[]string{"go", "vet", "{}"}, []string{"go", "vet", "src/a.go", "src/my file.go"}},
+		{[]string{"cp", "{file}", "out/{base}.bak"}, []string{"cp", "src/my file.go", "out/my file.bak"}},
+		// Only a lone {} is split
+		{[]string{"echo", "files: {}"}, []string{"echo", "files: src/a.go src/my file.go"}},
+	}
+	for _, tt := range tests {
+		if got := expandArgs(tt.argv, testChange); !slices.Equal(got, tt.want) {
+			t.Errorf("expandArgs(%q) = %q, want %q", tt.argv, got, tt.want)
+		}
+	}
+}
+
+func TestQuoteArg(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"main.go", "main.go"},
+		{"/srv/app/v1.2/x_y-z@2+3=5%,:", "/srv/app/v1.2/x_y-z@2+3=5%,:"},
+		{"", "''"},
+		{"my file.go", "'my file.go'"},
+		{"it's", `'it'\''s'`},
+		{"$(rm -rf ~)", "'$(rm -rf ~)'"},
+		{"a;b|c&d", "'a;b|c&d'"},
+		{"*.go", "'*.go'"},
+	}
+	for _, tt := range tests {
+		if got := quoteArg(tt.in); got != tt.want {
+			t.Errorf("quoteArg(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestQuoterFor(t *testing.T) {
+	tests := []struct {
+		shell, in, want string
+	}{
+		{"bash", "my file.go", "'my file.go'"},
+		{"/usr/bin/zsh -o globsubst", "it's", `'it'\''s'`},
+		{"fish", `it's a\b`, `'it\'s a\\b'`},
+		{"fish", "main.go", "main.go"},
+		{"pwsh", "main.go", "main.go"},
+		{"pwsh", `C:\src\my file.go`, `'C:\src\my file.go'`},
+		{"powershell.exe", "it's $(x) `y`", "'it''s $(x) `y`'"},
+		{"pwsh", "it\u2019s", "'it\u2019\u2019s'"},
+		{"pwsh", "a,b", "'a,b'"},
+		{"cmd", `C:\src\main.go`, `C:\src\main.go`},
+		{"cmd", `C:\my src\a&b^c.go`, `"C:\my src\a&b^c.go"`},
+		{"CMD.EXE", "a b", `"a b"`},
+	}
+	for _, tt := range tests {
+		quote := quoterFor(strings.Fields(tt.shell))
+		if got := quote(tt.in); got != tt.want {
+			t.Errorf("--shell %s: quoting %q = %s, want %s", tt.shell, tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// defaultPollInterval is used by --poll without an explicit interval.
+const defaultPollInterval = time.Second
+
+// fileState is what the poller remembers about a path between scans.
+type fileState struct {
+	size    int64
+	modTime time.Time
+	mode    os.FileMode
+}
+
+func stateOf(info os.FileInfo) fileState {
+	return fileState{size: info.Size(), modTime: info.ModTime(), mode: info.Mode()}
+}
+
+// poller is a notifier that stats the watched paths on a timer and
+// synthesizes fsnotify events from the differences. It works on
+// filesystems that never deliver inotify events, like NFS, SMB and
+// Docker Desktop bind mounts. Like fsnotify, watching a directory
+// reports changes to its direct children.
+type poller struct {
+	interval time.Duration
+	evs      chan fsnotify.Event
+	errs     chan error
+	done     chan struct{}
+
+	mu      sync.Mutex
+	files   map[string]fileState            // watched files
+	dirs    map[string]map[string]fileState // watched directories and their children
+	missing map[string]bool                 // watched files that disappeared
+}
+
+func newPoller(interval time.Duration) *poller {
+	p := &poller{
+		interval: interval,
+		evs:      make(chan fsnotify.Event, 64),
+		errs:     make(chan error, 1),
+		done:     make(chan struct{}),
+		files:    map[string]fileState{},
+		dirs:     map[string]map[string]fileState{},
+		missing:  map[string]bool{},
+	}
+	go p.run()
+	return p
+}
+
+func (p *poller) events() <-chan fsnotify.Event { return p.evs }
+func (p *poller) errors() <-chan error          { return p.errs }
+
+func (p *poller) Add(name string) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.missing, name)
+	if !info.IsDir() {
+		p.files[name] = stateOf(info)
+		return nil
+	}
+	children, err := scanDir(name)
+	if err != nil {
+		return err
+	}
+	p.dirs[name] = children
+	return nil
+}
+
+func (p *poller) Remove(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.files, name)
+	delete(p.dirs, name)
+	delete(p.missing, name)
+	return nil
+}
+
+func (p *poller) Close() error {
+	select {
+	case <-p.done:
+	default:
+		close(p.done)
+	}
+	return nil
+}
+
+func (p *poller) run() {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			for _, ev := range p.scan() {
+				select {
+				case p.evs <- ev:
+				case <-p.done:
+					return
+				}
+			}
+		}
+	}
+}
+
+// scan compares every watched path with its previous state.
+func (p *poller) scan() []fsnotify.Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var out []fsnotify.Event
+	for name, old := range p.files {
+		info, err := os.Stat(name)
+		if err != nil {
+			if !p.missing[name] {
+				p.missing[name] = true
+				out = append(out, fsnotify.Event{Name: name, Op: fsnotify.Remove})
+			}
+			continue
+		}
+		cur := stateOf(info)
+		if p.missing[name] {
+			delete(p.missing, name)
+			out = append(out, fsnotify.Event{Name: name, Op: fsnotify.Create})
+		} else if op, changed := diffState(old, cur); changed {
+			out = append(out, fsnotify.Event{Name: name, Op: op})
+		}
+		p.files[name] = cur
+	}
+
+	for dir, old := range p.dirs {
+		cur, err := scanDir(dir)
+		if err != nil {
+			delete(p.dirs, dir)
+			out = append(out, fsnotify.Event{Name: dir, Op: fsnotify.Remove})
+			continue
+		}
+		for name, st := range cur {
+			prev, ok := old[name]
+			if !ok {
+				out = append(out, fsnotify.Event{Name: name, Op: fsnotify.Create})
+			} else if op, changed := diffState(prev, st); changed {
+				out = append(out, fsnotify.Event{Name: name, Op: op})
+			}
+		}
+		for name := range old {
+			if _, ok := cur[name]; !ok {
+				out = append(out, fsnotify.Event{Name: name, Op: fsnotify.Remove})
+			}
+		}
+		p.dirs[dir] = cur
+	}
+	return out
+}
+
+func diffState(old, cur fileState) (fsnotify.Op, bool) {
+	if old.size != cur.size || !old.modTime.Equal(cur.modTime) {
+		return fsnotify.Write, true
+	}
+	if old.mode != cur.mode {
+		return fsnotify.Chmod, true
+	}
+	return 0, false
+}
+
+func scanDir(dir string) (map[string]fileState, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	children := make(map[string]fileState, len(entries))
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		children[filepath.Join(dir, e.Name())] = stateOf(info)
+	}
+	return children, nil
+}
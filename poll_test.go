@@ -0,0 +1,100 @@
+package main
+
+import (
+	"cmp"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestDiffState(t *testing.T) {
+	now := time.Now()
+	old := fileState{size: 10, modTime: now, mode: 0o644}
+	tests := []struct {
+		cur     fileState
+		op      fsnotify.Op
+		changed bool
+	}{
+		{old, 0, false},
+		{fileState{size: 11, modTime: now, mode: 0o644}, fsnotify.Write, true},
+		{fileState{size: 10, modTime: now.Add(time.Second), mode: 0o644}, fsnotify.Write, true},
+		{fileState{size: 10, modTime: now, mode: 0o600}, fsnotify.Chmod, true},
+		// Content wins over permissions
+		{fileState{size: 0, modTime: now, mode: 0o600}, fsnotify.Write, true},
+	}
+	for _, tt := range tests {
+		op, changed := diffState(old, tt.cur)
+		if op != tt.op || changed != tt.changed {
+			t.Errorf("diffState(%+v, %+v) = %v, %v, want %v, %v", old, tt.cur, op, changed, tt.op, tt.changed)
+		}
+	}
+}
+
+func TestPollerScan(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "watched.txt")
+	writeFiles(t, dir, "watched.txt", "old.txt")
+
+	// Scanned by hand, not on the timer
+	p := newPoller(time.Hour)
+	defer p.Close()
+	for _, name := range []string{file, dir} {
+		if err := p.Add(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if evs := p.scan(); len(evs) != 0 {
+		t.Fatalf("scan() without changes = %v", evs)
+	}
+
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "old.txt")); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, dir, "new.txt")
+	want := []fsnotify.Event{
+		{Name: filepath.Join(dir, "new.txt"), Op: fsnotify.Create},
+		{Name: filepath.Join(dir, "old.txt"), Op: fsnotify.Remove},
+		{Name: file, Op: fsnotify.Write},
+		{Name: file, Op: fsnotify.Write}, // as the directory's child too
+	}
+	if got := sortEvents(p.scan()); !slices.Equal(got, want) {
+		t.Errorf("scan() = %v, want %v", got, want)
+	}
+
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	want = []fsnotify.Event{{Name: file, Op: fsnotify.Remove}, {Name: file, Op: fsnotify.Remove}}
+	if got := p.scan(); !slices.Equal(got, want) {
+		t.Errorf("scan() after removing %s = %v, want %v", file, got, want)
+	}
+	// Reported once, and as created when it comes back
+	if got := p.scan(); len(got) != 0 {
+		t.Errorf("scan() again = %v, want nothing", got)
+	}
+	writeFiles(t, dir, "watched.txt")
+	want = []fsnotify.Event{{Name: file, Op: fsnotify.Create}, {Name: file, Op: fsnotify.Create}}
+	if got := p.scan(); !slices.Equal(got, want) {
+		t.Errorf("scan() after re-creating %s = %v, want %v", file, got, want)
+	}
+}
+
+// sortEvents orders events by name and then operation.
+func sortEvents(evs []fsnotify.Event) []fsnotify.Event {
+	slices.SortFunc(evs, func(a, b fsnotify.Event) int {
+		if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Op, b.Op)
+	})
+	return evs
+}
@@ -0,0 +1,124 @@
+//go:build !windows
+
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// credential is who the command runs as, from --user and --group.
+type credential = syscall.Credential
+
+// lookupCredential resolves --user and --group, given as names or ids.
+// Without --group the user's primary and supplementary groups are used.
+func lookupCredential(name, group string) (*credential, error) {
+	if name == "" && group == "" {
+		return nil, nil
+	}
+	cred := &credential{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid()), NoSetGroups: true}
+	if name != "" {
+		u, err := user.Lookup(name)
+		if err != nil {
+			if u, err = user.LookupId(name); err != nil {
+				return nil, err
+			}
+		}
+		cred.Uid = parseID(u.Uid)
+		cred.Gid = parseID(u.Gid)
+		if ids, err := u.GroupIds(); err == nil {
+			for _, id := range ids {
+				cred.Groups = append(cred.Groups, parseID(id))
+			}
+			cred.NoSetGroups = false
+		}
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil {
+				return nil, err
+			}
+		}
+		cred.Gid = parseID(g.Gid)
+	}
+	return cred, nil
+}
+
+func parseID(s string) uint32 {
+	n, _ := strconv.ParseUint(s, 10, 32)
+	return uint32(n)
+}
+
+// applyCredential makes cmd run as cred, if set.
+func applyCredential(cmd *exec.Cmd, cred *credential) {
+	if cred == nil {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = cred
+}
+
+// setProcessGroup puts the command in its own process group, so that
+// stopping it also stops what it started ("sh -c 'go run ./server'").
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// signalGroup sends sig to the process group of cmd.
+func signalGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	return syscall.Kill(-cmd.Process.Pid, sig)
+}
+
+// foreground reports whether we are in the foreground process group of
+// the terminal f, so reading from it does not stop us with SIGTTIN.
+func foreground(f *os.File) bool {
+	v, err := unix.IoctlGetInt(int(f.Fd()), unix.TIOCGPGRP)
+	if err != nil {
+		return false
+	}
+	// Reads fail instead of stopping us if we are moved to the background
+	signal.Ignore(syscall.SIGTTIN)
+	pgrp, err := unix.Getpgid(0)
+	return err == nil && pidFromInt(v) == pgrp
+}
+
+// pidFromInt returns the pid_t the kernel wrote into the first 4 bytes
+// of v, which on 64-bit big-endian systems are its high half.
+func pidFromInt(v int) int {
+	if strconv.IntSize == 32 {
+		return v
+	}
+	var b [8]byte
+	binary.NativeEndian.PutUint64(b[:], uint64(v))
+	return int(int32(binary.NativeEndian.Uint32(b[:4])))
+}
+
+// controlSignals returns channels receiving SIGUSR1, which forces a run,
+// and SIGUSR2, which toggles pausing.
+func controlSignals() (rerun, pause <-chan os.Signal) {
+	usr1 := make(chan os.Signal, 1)
+	usr2 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	signal.Notify(usr2, syscall.SIGUSR2)
+	return usr1, usr2
+}
+
+// private reports whether the file described by info belongs to us and
+// nobody else may access it.
+func private(info os.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && int(st.Uid) == os.Getuid() && info.Mode().Perm()&0o077 == 0
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// credential is who the command runs as; --user and --group are not
+// supported on Windows.
+type credential struct{}
+
+func lookupCredential(name, group string) (*credential, error) {
+	if name == "" && group == "" {
+		return nil, nil
+	}
+	return nil, errors.New("--user and --group are not supported on Windows")
+}
+
+func applyCredential(cmd *exec.Cmd, cred *credential) {}
+
+// setProcessGroup is a no-op, Windows has no process groups to signal.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalGroup signals just the process.
+func signalGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	if sig == syscall.SIGKILL {
+		return cmd.Process.Kill()
+	}
+	return cmd.Process.Signal(sig)
+}
+
+// foreground reports true, consoles have no background process groups.
+func foreground(f *os.File) bool { return true }
+
+// controlSignals returns nil channels, Windows has no SIGUSR1 and SIGUSR2.
+func controlSignals() (rerun, pause <-chan os.Signal) { return nil, nil }
+
+// private reports true, the temporary directory is per user on Windows.
+func private(info os.FileInfo) bool { return true }
@@ -0,0 +1,66 @@
+//go:build !windows
+
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+// startPTY starts cmd attached to a new pseudo-terminal, sized like our
+// own terminal and resized along with it, and copies its output to out.
+// The returned function drains the output once cmd has exited.
+func startPTY(cmd *exec.Cmd, out io.Writer) (func(), error) {
+	attrs := cmd.SysProcAttr
+	if attrs == nil {
+		attrs = &syscall.SysProcAttr{}
+	}
+	// The command becomes a session leader, and with that the leader of
+	// its own process group as well
+	attrs.Setpgid = false
+
+	size, _ := pty.GetsizeFull(os.Stdout)
+	ptmx, err := pty.StartWithAttrs(cmd, size, attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-winch:
+				if size, err := pty.GetsizeFull(os.Stdout); err == nil {
+					pty.Setsize(ptmx, size)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(out, ptmx)
+		close(copied)
+	}()
+
+	return func() {
+		signal.Stop(winch)
+		close(stop)
+		// Background processes may keep the terminal open
+		select {
+		case <-copied:
+		case <-time.After(time.Second):
+		}
+		ptmx.Close()
+	}, nil
+}
@@ -0,0 +1,12 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os/exec"
+)
+
+// startPTY is not supported on Windows.
+func startPTY(cmd *exec.Cmd, out io.Writer) (func(), error) {
+	return nil, errors.New("--pty is not supported on Windows")
+}
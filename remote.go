@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// source is something outside the local filesystem that is checked on
+// a timer, like files on another machine or a URL.
+type source interface {
+	String() string
+	// check returns a version for every item of the source, keyed by the
+	// name to report in events.
+	check() (map[string]string, error)
+}
+
+// remoteWatcher polls a source and synthesizes events from the
+// differences between two checks.
+type remoteWatcher struct {
+	src      source
+	interval time.Duration
+	evs      chan<- fsnotify.Event
+	done     chan struct{}
+}
+
+// startRemotes starts a watcher for every source, all delivering their
+// events to the returned channel.
+func startRemotes(srcs []source, interval time.Duration) (<-chan fsnotify.Event, []*remoteWatcher) {
+	evs := make(chan fsnotify.Event)
+	var remotes []*remoteWatcher
+	for _, src := range srcs {
+		r := &remoteWatcher{
+			src:      src,
+			interval: interval,
+			evs:      evs,
+			done:     make(chan struct{}),
+		}
+		go r.run()
+		remotes = append(remotes, r)
+	}
+	return evs, remotes
+}
+
+func stopRemotes(remotes []*remoteWatcher) {
+	for _, r := range remotes {
+		close(r.done)
+	}
+}
+
+func (r *remoteWatcher) String() string {
+	return r.src.String()
+}
+
+func (r *remoteWatcher) run() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	// the first successful listing is the baseline
+	var known map[string]string
+	failing := false
+	for {
+		cur, err := r.src.check()
+		if err != nil {
+			if !failing {
+				warnf("Warning: Cannot poll %s: %v\n", r, err)
+			}
+			failing = true
+		} else {
+			if failing {
+				warnf("Polling %s again\n", r)
+			}
+			failing = false
+			if known != nil {
+				for _, ev := range r.diff(known, cur) {
+					select {
+					case r.evs <- ev:
+					case <-r.done:
+						return
+					}
+				}
+			}
+			known = cur
+		}
+
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sshSource is a file or directory on another machine. Each check runs
+// cksum over ssh, so nothing but a POSIX shell is needed on the server.
+type sshSource struct {
+	host string // [user@]host
+	path string
+}
+
+// newSSHSource parses "[user@]host:/path".
+func newSSHSource(spec string) (*sshSource, error) {
+	host, path, ok := splitRemote(spec)
+	if !ok {
+		return nil, fmt.Errorf("invalid remote %q, expected [user@]host:/path", spec)
+	}
+	return &sshSource{host: host, path: path}, nil
+}
+
+func (r *sshSource) String() string {
+	return r.name(r.path)
+}
+
+// name returns how a path on the host is shown and reported in events,
+// with an IPv6 address in brackets.
+func (r *sshSource) name(path string) string {
+	host := r.host
+	if strings.Contains(host, ":") {
+		i := strings.LastIndex(host, "@") + 1
+		host = host[:i] + "[" + host[i:] + "]"
+	}
+	return host + ":" + path
+}
+
+// splitRemote splits "[user@]host:path" into the host, as passed to
+// ssh, and the path. IPv6 addresses are either in brackets, as in
+// [::1]:/path, or end at the last colon before the path's first slash,
+// as in user@fe80::1:/path.
+func splitRemote(spec string) (host, path string, ok bool) {
+	end := len(spec)
+	if i := strings.Index(spec, "/"); i >= 0 {
+		end = i
+	}
+	if i := strings.Index(spec[:end], "["); i >= 0 {
+		j := strings.Index(spec, "]:")
+		if j < i {
+			return "", "", false
+		}
+		host, path = spec[:i]+spec[i+1:j], spec[j+2:]
+	} else {
+		j := strings.LastIndex(spec[:end], ":")
+		if j < 0 {
+			return "", "", false
+		}
+		host, path = spec[:j], spec[j+1:]
+	}
+	return host, path, host != "" && !strings.HasSuffix(host, "@") && path != ""
+}
+
+// isRemote reports whether path names a file on another machine, as
+// the events of --remote do. Like for scp, a local path with a colon
+// before its first slash looks the same, so it is only taken for a
+// remote one when nothing by that name exists here.
+func isRemote(path string) bool {
+	if _, _, ok := splitRemote(path); !ok {
+		return false
+	}
+	_, err := os.Lstat(path)
+	return err != nil
+}
+
+// check returns a checksum per remote file.
+func (r *sshSource) check() (map[string]string, error) {
+	script := "find " + shellQuote(r.path) + " -type f -exec cksum {} +"
+	cmd := exec.Command("ssh", "-o", "BatchMode=yes", "-o", "ConnectTimeout=10", r.host, script)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%v: %s", err, msg)
+		}
+		return nil, err
+	}
+
+	files := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		// "<crc> <size> <path>"
+		fields := strings.SplitN(scanner.Text(), " ", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		files[r.name(fields[2])] = fields[0] + " " + fields[1]
+	}
+	return files, nil
+}
+
+func (r *remoteWatcher) diff(old, cur map[string]string) []fsnotify.Event {
+	var out []fsnotify.Event
+	for name, version := range cur {
+		prev, ok := old[name]
+		if !ok {
+			out = append(out, fsnotify.Event{Name: name, Op: fsnotify.Create})
+		} else if prev != version {
+			out = append(out, fsnotify.Event{Name: name, Op: fsnotify.Write})
+		}
+	}
+	for name := range old {
+		if _, ok := cur[name]; !ok {
+			out = append(out, fsnotify.Event{Name: name, Op: fsnotify.Remove})
+		}
+	}
+	return out
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
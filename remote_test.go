@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewSSHSource(t *testing.T) {
+	tests := []struct {
+		spec, host, path, name string
+	}{
+		{"build:/srv/app", "build", "/srv/app", "build:/srv/app"},
+		{"deploy@build:/srv/app", "deploy@build", "/srv/app", "deploy@build:/srv/app"},
+		{"build:logs", "build", "logs", "build:logs"},
+		{"build:/srv/a:b", "build", "/srv/a:b", "build:/srv/a:b"},
+		{"[::1]:/srv/app", "::1", "/srv/app", "[::1]:/srv/app"},
+		{"deploy@[fe80::1%eth0]:/p", "deploy@fe80::1%eth0", "/p", "deploy@[fe80::1%eth0]:/p"},
+		{"user@fe80::1:/p", "user@fe80::1", "/p", "user@[fe80::1]:/p"},
+		{"::1:/srv/app", "::1", "/srv/app", "[::1]:/srv/app"},
+	}
+	for _, tt := range tests {
+		src, err := newSSHSource(tt.spec)
+		if err != nil {
+			t.Errorf("newSSHSource(%q): %v", tt.spec, err)
+			continue
+		}
+		if src.host != tt.host || src.path != tt.path {
+			t.Errorf("newSSHSource(%q) = host %q, path %q, want %q, %q", tt.spec, src.host, src.path, tt.host, tt.path)
+		}
+		if got := src.String(); got != tt.name {
+			t.Errorf("newSSHSource(%q).String() = %q, want %q", tt.spec, got, tt.name)
+		}
+	}
+
+	for _, bad := range []string{"", "build", "/srv/app", ":/srv/app", "build:", "user@:/p", "[::1]/p", "[::1]:"} {
+		if _, err := newSSHSource(bad); err == nil {
+			t.Errorf("newSSHSource(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestAbsPathRemote(t *testing.T) {
+	dir := chdirTemp(t)
+	writeFiles(t, dir, "12:00.log")
+	tests := []struct {
+		in, want string
+	}{
+		{"build:/srv/app/main.go", "build:/srv/app/main.go"},
+		{"[::1]:/srv/app/main.go", "[::1]:/srv/app/main.go"},
+		{"https://example.com/a", "https://example.com/a"},
+		{"12:00.log", filepath.Join(dir, "12:00.log")},
+		{"src/a:b.go", filepath.Join(dir, "src/a:b.go")},
+	}
+	for _, tt := range tests {
+		if got := absPath(tt.in); got != tt.want {
+			t.Errorf("absPath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// chdirTemp changes to a temporary directory for the rest of the test.
+func chdirTemp(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	// The temporary directory may be behind a symlink
+	dir, err = os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// rule is a command together with the changes it reacts to. Without
+// --rule or --config there is a single rule for the command after "--".
+type rule struct {
+	pattern string // empty matches every change
+	command string
+	run     *runner
+	sched   *scheduler
+
+	// For the named rules of a --config file
+	name   string
+	paths  []string    // empty matches every change
+	filter *pathFilter // the rule's own --exclude, --ext, ...
+}
+
+// ruleSet routes changes to the rules they match.
+type ruleSet struct {
+	rules   []*rule
+	filter  *pathFilter
+	signals *runSignals
+	changes map[string]int // how often each path changed, for the summary
+}
+
+// add hands a change to every rule matching it.
+func (rs *ruleSet) add(name string, op fsnotify.Op) {
+	matched := false
+	for _, r := range rs.rules {
+		if r.matches(rs.filter, name) {
+			r.sched.add(name, op)
+			matched = true
+		} else {
+			debugf("%s does not match the rule for %s", name, r.command)
+		}
+	}
+	if matched {
+		if rs.changes == nil {
+			rs.changes = map[string]int{}
+		}
+		rs.changes[name]++
+	}
+}
+
+// matches reports whether a change to name is for r.
+func (r *rule) matches(f *pathFilter, name string) bool {
+	if r.pattern != "" && !f.matches(r.pattern, name) {
+		return false
+	}
+	if r.filter != nil && !r.filter.accepts(name) {
+		return false
+	}
+	if len(r.paths) == 0 {
+		return true
+	}
+	for _, p := range r.paths {
+		if hasMeta(p) {
+			if f.matches(p, name) {
+				return true
+			}
+		} else if within(absPath(p), absPath(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// runAll runs every rule's command for c and waits for them.
+func (rs *ruleSet) runAll(c change) {
+	for _, r := range rs.rules {
+		r.run.run(c)
+	}
+}
+
+// force runs every rule's command right away.
+func (rs *ruleSet) force() {
+	for _, r := range rs.rules {
+		go r.sched.force()
+	}
+}
+
+// shutdown stops every command still running.
+func (rs *ruleSet) shutdown() {
+	for _, r := range rs.rules {
+		r.run.shutdown()
+	}
+}
+
+// printStats prints how long the runs of every rule took so far.
+func (rs *ruleSet) printStats() {
+	for _, r := range rs.rules {
+		if stats := r.run.timings(); stats.n > 0 {
+			logf("[%s] %s\n", r.command, stats)
+		}
+	}
+}
+
+// printSummary prints what happened during the session, when
+// on_change stops: how often the commands ran and failed, and the files
+// that changed most, which shows when something keeps triggering runs.
+func (rs *ruleSet) printSummary() {
+	var triggers, runs, failed int
+	var total time.Duration
+	for _, r := range rs.rules {
+		stats := r.run.timings()
+		triggers += r.sched.triggerCount()
+		runs += stats.n
+		failed += stats.failed
+		total += stats.total
+	}
+	top := mostChanged(rs.changes, 5)
+	var counts []string
+	for _, name := range top {
+		counts = append(counts, fmt.Sprintf("%s (%d)", name, rs.changes[name]))
+	}
+	msg := fmt.Sprintf("Session: %d trigger(s), %d run(s), %d failed, %s running commands\n",
+		triggers, runs, failed, formatDuration(total))
+	if len(counts) > 0 {
+		msg += "Most changed: " + strings.Join(counts, ", ") + "\n"
+	}
+	logEvent("summary", fields{"triggers": triggers, "runs": runs, "failed": failed,
+		"duration_ms": total.Milliseconds(), "most_changed": top}, "%s", msg)
+	rs.printStats()
+}
+
+// mostChanged returns the n paths with the most changes, most first.
+func mostChanged(changes map[string]int, n int) []string {
+	names := make([]string, 0, len(changes))
+	for name := range changes {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		return cmp.Or(changes[b]-changes[a], strings.Compare(a, b))
+	})
+	return names[:min(n, len(names))]
+}
+
+// exitStatus returns the first failing exit status of the rules.
+func (rs *ruleSet) exitStatus() int {
+	for _, r := range rs.rules {
+		if code := r.run.exitStatus(); code != 0 {
+			return code
+		}
+	}
+	return 0
+}
+
+// commandFiles returns the scripts given with --command-file, for the
+// command line and every rule, which are watched too.
+func commandFiles(opts *options) []string {
+	var files []string
+	if opts.commandFile != "" {
+		files = append(files, opts.commandFile)
+	}
+	for _, spec := range opts.rules {
+		if spec.opts != nil && spec.opts.commandFile != "" && !slices.Contains(files, spec.opts.commandFile) {
+			files = append(files, spec.opts.commandFile)
+		}
+	}
+	return files
+}
@@ -0,0 +1,787 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultKillTimeout is how long a process gets to exit after being
+// asked to before it is killed.
+const defaultKillTimeout = 5 * time.Second
+
+// runner starts the command. Normally every run is waited for, in
+// restart mode the previous instance is stopped and the new one is left
+// running in the background.
+type runner struct {
+	command     string
+	commandFile string
+	argv        []string // the command's words, for --no-shell
+	noShell     bool
+	shell       []string
+	argsAppend  bool
+	stdinFiles  stdinFilesFlag
+	restart     bool
+	signal      syscall.Signal
+	killTimeout time.Duration
+	timeout     time.Duration
+	retries     int
+	onSuccess   string
+	onFailure   string
+	before      string
+	after       string
+	dir         string
+	vars        []string // from --env and --env-file
+	cleanEnv    bool
+	docker      string
+	dryRun      bool
+	priority    []string // nice and ionice prefix
+	pty         bool
+	prefix      string
+	notify      bool
+	bell        bool
+	collapse    collapseFlag
+	liveReload  *liveReload // nil without --livereload
+	webhooks    []string
+	chat        *chatNotifier   // nil without --chat
+	rule        string          // the rule's name or command, for metrics
+	status      bool            // stdout is a terminal showing a --status line
+	stdin       *stdinForwarder // nil unless --forward-stdin
+	cred        *credential
+	cgroups     *cgroupManager // nil without resource limits
+
+	mu      sync.Mutex
+	current *process // running instance in restart mode
+	running map[*process]bool
+
+	lastCode int       // exit status of the last run that finished
+	lastEnd  time.Time // when it finished
+	failing  bool      // the last run failed
+	stats    runStats
+}
+
+// process is a started command.
+type process struct {
+	cmd      *exec.Cmd
+	label    string
+	done     chan struct{} // closed once the process was waited for
+	err      error
+	stopped  bool          // stopped on purpose, do not report the exit
+	timedOut bool          // stopped by --timeout, only set before done is closed
+	settled  chan struct{} // in restart mode, closed once the exit was handled
+	command  string        // as shown to the user
+	started  time.Time
+	output   *collapsedOutput // nil unless --collapse
+}
+
+func newRunner(opts *options, vars []string, cred *credential, cgroups *cgroupManager) *runner {
+	return &runner{
+		command:     opts.command,
+		commandFile: opts.commandFile,
+		argv:        opts.argv,
+		noShell:     opts.noShell,
+		shell:       strings.Fields(opts.shell),
+		argsAppend:  opts.argsAppend,
+		stdinFiles:  opts.stdinFiles,
+		restart:     opts.restart,
+		signal:      opts.signal.sig,
+		killTimeout: opts.killTimeout,
+		timeout:     opts.timeout,
+		retries:     opts.retries,
+		onSuccess:   opts.onSuccess,
+		onFailure:   opts.onFailure,
+		before:      opts.before,
+		after:       opts.after,
+		dir:         opts.cwd,
+		vars:        vars,
+		cleanEnv:    opts.cleanEnv,
+		docker:      opts.docker,
+		dryRun:      opts.dryRun,
+		priority:    priorityArgs(opts),
+		pty:         opts.pty,
+		prefix:      opts.prefix,
+		notify:      opts.notify,
+		bell:        opts.bell,
+		collapse:    opts.collapse,
+		webhooks:    opts.webhooks,
+		chat:        newChatNotifier(opts),
+		status:      opts.status && isTerminal(os.Stdout) && !opts.quiet && opts.logFormat != "json" && !opts.tui,
+		cred:        cred,
+		cgroups:     cgroups,
+		running:     map[*process]bool{},
+	}
+}
+
+// run executes the command for a change.
+func (r *runner) run(c change) {
+	label := strings.Join(c.watched, ", ")
+	if r.dir != "" && r.docker == "" {
+		// Relative paths would not resolve from the command's directory
+		c = c.abs()
+	}
+	if r.dryRun {
+		r.describe(c, label)
+		return
+	}
+	if r.restart {
+		if !terminates(r.signal) && r.reload(label) {
+			return
+		}
+		r.stop()
+
+		r.runBefore(c, label)
+		p := r.start(c, label)
+		if p == nil {
+			r.runAfter(c, label, -1)
+			return
+		}
+		p.settled = make(chan struct{})
+		r.mu.Lock()
+		r.current = p
+		r.mu.Unlock()
+		go func() {
+			defer close(p.settled)
+			<-p.done
+			r.mu.Lock()
+			stopped := p.stopped
+			r.mu.Unlock()
+			if stopped {
+				r.runAfter(c, label, p.exitCode())
+				return
+			}
+			p.report()
+			r.runAfter(c, label, p.exitCode())
+			r.finished(c, p)
+			logf("[%s] Waiting for changes to restart\n\n", label)
+		}()
+		return
+	}
+
+	for attempt := 1; ; attempt++ {
+		r.runBefore(c, label)
+		p := r.start(c, label)
+		if p == nil {
+			r.runAfter(c, label, -1)
+			return
+		}
+		<-p.done
+		r.mu.Lock()
+		stopped, timedOut := p.stopped, p.timedOut
+		r.mu.Unlock()
+		if !stopped {
+			p.report()
+		}
+		r.runAfter(c, label, p.exitCode())
+		if stopped {
+			return
+		}
+		if !timedOut || attempt > r.retries {
+			r.finished(c, p)
+			logf("\n")
+			return
+		}
+		logf("[%s] Retrying (%d/%d)\n", label, attempt, r.retries)
+	}
+}
+
+// commandArgs returns the argv running the command for c, and the
+// command as shown to the user.
+func (r *runner) commandArgs(c change) (args []string, shown string, err error) {
+	command, argv := r.command, r.argv
+	if r.commandFile != "" {
+		args, err = r.scriptArgs(c)
+		if err != nil {
+			return nil, "", err
+		}
+		shown = r.commandFile
+	} else if r.noShell {
+		args = expandArgs(argv, c)
+		if r.argsAppend {
+			args = append(args, c.files...)
+		}
+		shown = strings.Join(args, " ")
+	} else {
+		// Use shell to execute the command to support pipes, redirects, etc.
+		shell := r.shell
+		if len(shell) == 0 && r.docker != "" {
+			// $SHELL is ours and may not exist in the container
+			shell = []string{"sh"}
+		}
+		quote := quoterFor(shell)
+		command = expand(command, c, quote)
+		if r.argsAppend {
+			for _, f := range c.files {
+				command += " " + quote(f)
+			}
+		}
+		shown = command
+		args = shellArgs(shell, command)
+	}
+	args = append(append([]string(nil), r.priority...), args...)
+	if r.docker != "" {
+		args = r.dockerArgs(args, c)
+	}
+	return args, shown, nil
+}
+
+// scriptArgs returns the argv running the --command-file for c, with
+// the changed files as its arguments. The script is not a command line:
+// its {} are left alone, it finds the change in $ON_CHANGE_FILE and the
+// like. With a #! line it is executed itself, otherwise by the shell.
+func (r *runner) scriptArgs(c change) ([]string, error) {
+	// Read on every run to pick up edits
+	b, err := os.ReadFile(r.commandFile)
+	if err != nil {
+		return nil, err
+	}
+	if r.docker != "" {
+		// The file is ours, the container gets its contents
+		return append([]string{"sh", "-c", string(b), "sh"}, c.files...), nil
+	}
+	path := absPath(r.commandFile)
+	var args []string
+	if info, err := os.Stat(path); err == nil && bytes.HasPrefix(b, []byte("#!")) && info.Mode()&0o111 != 0 {
+		args = []string{path}
+	} else {
+		args = shellOf(r.shell)
+		switch shellName(args[0]) {
+		case "powershell", "pwsh":
+			args = append(args, "-File")
+		case "cmd":
+			args = append(args, "/C")
+		}
+		args = append(args, path)
+	}
+	return append(args, c.files...), nil
+}
+
+// describe prints what would run for c, for --dry-run.
+func (r *runner) describe(c change, label string) {
+	args, shown, err := r.commandArgs(c)
+	if err != nil {
+		fmt.Printf("[%s] Command error: %v\n\n", label, err)
+		return
+	}
+	fmt.Printf("[%s] Would execute: %s\n", label, shown)
+	fmt.Printf("  argv: %q\n", args)
+	if r.dir != "" {
+		fmt.Printf("  in: %s\n", r.dir)
+	}
+	for _, kv := range append(append([]string(nil), r.vars...), c.env()...) {
+		fmt.Printf("  env: %s\n", kv)
+	}
+	fmt.Println()
+}
+
+// start starts the command and returns nil if that failed.
+func (r *runner) start(c change, label string) *process {
+	args, shown, err := r.commandArgs(c)
+	if err != nil {
+		logf("[%s] Command error: %v\n\n", label, err)
+		r.mu.Lock()
+		r.lastCode = 127
+		r.mu.Unlock()
+		return nil
+	}
+	logEvent("run", fields{"command": shown, "files": c.files}, "[%s] Executing: %s\n", label, shown)
+	setTitle("running")
+	postWebhooks(r.webhooks, fields{"event": "start", "command": shown, "files": c.files})
+
+	cmd := exec.Command(args[0], args[1:]...)
+	if r.docker == "" {
+		// With --docker, --cwd is a directory in the container
+		cmd.Dir = r.dir
+	}
+	applyCredential(cmd, r.cred)
+	setProcessGroup(cmd)
+	cmd.Env = append(baseEnv(r.cleanEnv), r.vars...)
+	cmd.Env = append(cmd.Env, c.env()...)
+	if r.stdinFiles.enabled {
+		sep := "\n"
+		if r.stdinFiles.nul {
+			sep = "\x00"
+		}
+		cmd.Stdin = strings.NewReader(strings.Join(c.files, sep) + sep)
+	}
+	var stdout, stderr io.Writer = os.Stdout, os.Stderr
+	if r.prefix != "" || r.status || shownDashboard() != nil {
+		stdout, stderr = console{os.Stdout}, console{os.Stderr}
+	}
+	var collapsed *collapsedOutput
+	if r.collapse.enabled {
+		collapsed = &collapsedOutput{lines: r.collapse.lines}
+		stdout, stderr = collapsed.writers(stdout, stderr)
+	}
+	flush := func() {}
+	if r.prefix != "" {
+		o, e := newPrefixWriter(stdout, r.prefix), newPrefixWriter(stderr, r.prefix)
+		stdout, stderr = o, e
+		flush = func() { o.Flush(); e.Flush() }
+	}
+	if eventStream != nil {
+		stdout = io.MultiWriter(stdout, outputStream{"stdout", shown})
+		stderr = io.MultiWriter(stderr, outputStream{"stderr", shown})
+	}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	cleanup, drain := func() {}, func() {}
+	if r.cgroups != nil {
+		cleanup, err = r.cgroups.apply(cmd)
+	}
+	var stdin io.WriteCloser
+	if err == nil && r.stdin != nil {
+		stdin, err = cmd.StdinPipe()
+	}
+	if err == nil && r.pty {
+		cmd.Stdout, cmd.Stderr = nil, nil
+		drain, err = startPTY(cmd, stdout)
+	} else if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		cleanup()
+		logf("[%s] Command error: %v\n", label, err)
+		logf("\n")
+		r.mu.Lock()
+		r.lastCode = 127
+		r.mu.Unlock()
+		return nil
+	}
+	metrics.runStarted(r.rule)
+	p := &process{cmd: cmd, label: label, done: make(chan struct{}), command: shown, started: time.Now(), output: collapsed}
+	r.mu.Lock()
+	r.running[p] = true
+	r.mu.Unlock()
+	if stdin != nil {
+		r.stdin.attach(stdin)
+	}
+	go func() {
+		p.err = cmd.Wait()
+		if stdin != nil {
+			r.stdin.detach(stdin)
+		}
+		drain()
+		flush()
+		cleanup()
+		r.mu.Lock()
+		delete(r.running, p)
+		close(p.done)
+		r.mu.Unlock()
+	}()
+
+	if r.status {
+		go spin(p)
+	}
+	if r.timeout > 0 {
+		go func() {
+			timer := time.NewTimer(r.timeout)
+			defer timer.Stop()
+			select {
+			case <-p.done:
+			case <-timer.C:
+				r.mu.Lock()
+				select {
+				case <-p.done:
+					// It exited by itself just as the timer fired
+					r.mu.Unlock()
+					return
+				default:
+				}
+				p.timedOut = true
+				r.mu.Unlock()
+				r.terminate(p, fmt.Sprintf("Timed out after %s, stopping", r.timeout))
+			}
+		}()
+	}
+	return p
+}
+
+// spin shows how long p has been running on a status line until it
+// exits, for --status.
+func spin(p *process) {
+	const frames = `|/-\`
+	command := p.command
+	if len(command) > 40 {
+		command = command[:39] + "…"
+	}
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for i := 0; ; i++ {
+		select {
+		case <-p.done:
+			setStatus("")
+			return
+		case <-ticker.C:
+			setStatus(fmt.Sprintf("%c %s… %s", frames[i%len(frames)], command, time.Since(p.started).Round(time.Second)))
+		}
+	}
+}
+
+// runBefore runs the --before hook.
+func (r *runner) runBefore(c change, label string) {
+	if r.before != "" {
+		r.hook(r.before, c, label)
+	}
+}
+
+// runAfter runs the --after hook, whatever the outcome of the run.
+func (r *runner) runAfter(c change, label string, code int) {
+	if r.after != "" {
+		r.hook(r.after, c, label, fmt.Sprintf("ON_CHANGE_EXIT_CODE=%d", code))
+	}
+}
+
+// finished runs the --on-success or --on-failure hook for p.
+func (r *runner) finished(c change, p *process) {
+	failed := p.timedOut || p.err != nil
+	r.mu.Lock()
+	r.lastCode = p.exitCode()
+	r.lastEnd = time.Now()
+	wasFailing := r.failing
+	r.failing = failed
+	r.stats.add(time.Since(p.started), failed)
+	r.mu.Unlock()
+	metrics.runFinished(r.rule, time.Since(p.started), failed)
+
+	postWebhooks(r.webhooks, fields{
+		"event":       "finish",
+		"command":     p.command,
+		"files":       c.files,
+		"exit_code":   p.exitCode(),
+		"duration_ms": time.Since(p.started).Milliseconds(),
+		"failed":      failed,
+	})
+	r.chat.finished(failed, wasFailing, chatMessage{
+		Command:  p.command,
+		Rule:     r.rule,
+		ExitCode: p.exitCode(),
+		Duration: formatDuration(time.Since(p.started)),
+		Files:    c.files,
+	})
+
+	command := r.onSuccess
+	if failed {
+		command = r.onFailure
+	} else if r.liveReload != nil {
+		r.liveReload.reload(c.last)
+	}
+	if r.bell && failed {
+		ringBell()
+	}
+	if r.notify {
+		took := formatDuration(time.Since(p.started))
+		if failed {
+			notify("on_change: FAILED", fmt.Sprintf("%s\nexit %d after %s", p.command, p.exitCode(), took), true)
+		} else {
+			notify("on_change: OK", fmt.Sprintf("%s\nfinished in %s", p.command, took), false)
+		}
+	}
+	if command != "" {
+		r.hook(command, c, p.label, fmt.Sprintf("ON_CHANGE_EXIT_CODE=%d", p.exitCode()))
+	}
+}
+
+// hook runs a hook command through the shell and waits for it. It sees
+// the same placeholders and environment as the command itself.
+func (r *runner) hook(command string, c change, label string, env ...string) {
+	args := shellArgs(r.shell, expand(command, c, quoterFor(r.shell)))
+	cmd := exec.Command(args[0], args[1:]...)
+	if r.docker == "" {
+		// Hooks run here, --cwd is a directory in the container
+		cmd.Dir = r.dir
+	}
+	applyCredential(cmd, r.cred)
+	cmd.Env = append(baseEnv(r.cleanEnv), r.vars...)
+	cmd.Env = append(append(cmd.Env, c.env()...), env...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if r.status || shownDashboard() != nil {
+		// Taking turns with the status line and the dashboard
+		cmd.Stdout, cmd.Stderr = console{os.Stdout}, console{os.Stderr}
+	}
+	if err := cmd.Run(); err != nil {
+		logf("[%s] Hook '%s' failed: %v\n", label, command, err)
+	}
+}
+
+// priorityArgs returns the nice and ionice invocation running the
+// command at the --nice and --ionice priority. Wrapping the command
+// applies them before it starts, so everything it spawns inherits them.
+func priorityArgs(opts *options) []string {
+	var args []string
+	if opts.nice != 0 {
+		args = append(args, "nice", "-n", strconv.Itoa(opts.nice))
+	}
+	if opts.ionice.class != 0 {
+		args = append(args, "ionice", "-c", strconv.Itoa(opts.ionice.class))
+		if opts.ionice.level >= 0 {
+			args = append(args, "-n", strconv.Itoa(opts.ionice.level))
+		}
+	}
+	return args
+}
+
+// dockerArgs wraps args in docker exec for --docker. Our environment is
+// not visible in the container, so the --env and ON_CHANGE_* variables
+// are passed explicitly.
+func (r *runner) dockerArgs(args []string, c change) []string {
+	out := []string{"docker", "exec"}
+	if r.stdinFiles.enabled {
+		// without -i docker exec does not forward stdin
+		out = append(out, "-i")
+	}
+	if r.dir != "" {
+		out = append(out, "-w", r.dir)
+	}
+	for _, kv := range append(append([]string(nil), r.vars...), c.env()...) {
+		out = append(out, "-e", kv)
+	}
+	out = append(out, r.docker)
+	return append(out, args...)
+}
+
+// timings returns the durations of the runs so far.
+func (r *runner) timings() runStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+// state returns whether the command is running and how the last run
+// ended, for the HTTP API.
+func (r *runner) state() (running bool, lastEnd time.Time, lastCode int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.running) > 0, r.lastEnd, r.lastCode
+}
+
+// runStats sums up the durations of finished runs.
+type runStats struct {
+	n                int
+	failed           int
+	total            time.Duration
+	fastest, slowest time.Duration
+}
+
+func (s *runStats) add(d time.Duration, failed bool) {
+	if failed {
+		s.failed++
+	}
+	if s.n == 0 || d < s.fastest {
+		s.fastest = d
+	}
+	if d > s.slowest {
+		s.slowest = d
+	}
+	s.n++
+	s.total += d
+}
+
+func (s runStats) String() string {
+	return fmt.Sprintf("%d run(s), min %s, avg %s, max %s", s.n,
+		formatDuration(s.fastest), formatDuration(s.total/time.Duration(s.n)), formatDuration(s.slowest))
+}
+
+// exitStatus returns the exit status of the last finished run, for
+// --exit-status.
+func (r *runner) exitStatus() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.lastCode < 0 {
+		return 1
+	}
+	return r.lastCode
+}
+
+// shellArgs returns the argv running command under shell, which may
+// carry its own arguments ("bash -O globstar"). It defaults to $SHELL and
+// then to sh.
+func shellArgs(shell []string, command string) []string {
+	args := shellOf(shell)
+	flag := "-c"
+	switch shellName(args[0]) {
+	case "powershell", "pwsh":
+		flag = "-Command"
+	case "cmd":
+		flag = "/C"
+	}
+	return append(args, flag, command)
+}
+
+// shellOf returns the --shell, by default $SHELL or sh.
+func shellOf(shell []string) []string {
+	if len(shell) == 0 {
+		shell = []string{os.Getenv("SHELL")}
+		if shell[0] == "" {
+			shell[0] = "sh"
+		}
+	}
+	return append([]string(nil), shell...)
+}
+
+// shellName returns the name of a shell's program, as "pwsh".
+func shellName(program string) string {
+	return strings.TrimSuffix(strings.ToLower(filepath.Base(program)), ".exe")
+}
+
+// reload delivers the signal to the running instance, for daemons that
+// reload on SIGHUP or similar. It reports false if nothing is running.
+func (r *runner) reload(label string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p := r.current
+	if p == nil {
+		return false
+	}
+	select {
+	case <-p.done:
+		return false
+	default:
+	}
+	logf("[%s] Sending %v to running command\n", label, r.signal)
+	if err := p.cmd.Process.Signal(r.signal); err != nil {
+		logf("[%s] Command error: %v\n", label, err)
+	}
+	return true
+}
+
+// stop terminates the running instance, if any, and waits for it.
+func (r *runner) stop() {
+	r.mu.Lock()
+	p := r.current
+	r.current = nil
+	if p != nil {
+		p.stopped = true
+	}
+	r.mu.Unlock()
+	if p != nil {
+		r.terminate(p, "Stopping previous run")
+		<-p.settled
+	}
+}
+
+// shutdown terminates every command still running, on exit.
+func (r *runner) shutdown() {
+	r.stopAll("Stopping")
+}
+
+// stopAll terminates every running command and waits for them.
+func (r *runner) stopAll(msg string) {
+	r.mu.Lock()
+	var procs []*process
+	for p := range r.running {
+		p.stopped = true
+		procs = append(procs, p)
+	}
+	r.current = nil
+	r.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, p := range procs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.terminate(p, msg)
+			if p.settled != nil {
+				<-p.settled
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// terminate asks p to exit, kills it after the kill timeout and waits
+// for it.
+func (r *runner) terminate(p *process, msg string) {
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+
+	sig := r.signal
+	if !terminates(sig) {
+		sig = syscall.SIGTERM
+	}
+	logf("[%s] %s\n", p.label, msg)
+	if err := signalGroup(p.cmd, sig); err != nil {
+		signalGroup(p.cmd, syscall.SIGKILL)
+	}
+	select {
+	case <-p.done:
+	case <-time.After(r.killTimeout):
+		logf("[%s] Did not exit within %s, killing\n", p.label, r.killTimeout)
+		signalGroup(p.cmd, syscall.SIGKILL)
+		<-p.done
+	}
+}
+
+// terminates reports whether sig is meant to end a process, as opposed
+// to signals like SIGHUP that daemons handle in place.
+func terminates(sig syscall.Signal) bool {
+	switch sig {
+	case syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGKILL:
+		return true
+	}
+	return false
+}
+
+// exitCode returns the command's exit status, or -1 if it did not exit
+// normally.
+func (p *process) exitCode() int {
+	if p.timedOut {
+		return -1
+	}
+	if p.err == nil {
+		return 0
+	}
+	if exitErr, ok := p.err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// report prints how a run ended, as a green OK or a red FAILED banner
+// with its duration. With --log-format json every run gets an exit
+// record.
+func (p *process) report() {
+	elapsed := time.Since(p.started)
+	f := fields{
+		"command":     p.command,
+		"exit_code":   p.exitCode(),
+		"duration_ms": elapsed.Milliseconds(),
+	}
+	took := formatDuration(elapsed)
+	if p.timedOut || p.err != nil {
+		setTitle("FAILED")
+		if p.output != nil {
+			p.output.show()
+		}
+	} else {
+		setTitle("idle")
+		if p.output != nil {
+			p.output.tail()
+		}
+	}
+	if p.timedOut {
+		logEvent("exit", f, "[%s] %s\n", p.label, red("FAILED timed out ("+took+")"))
+		return
+	}
+	if p.err == nil {
+		logEvent("exit", f, "[%s] %s\n", p.label, green("OK ("+took+")"))
+		return
+	}
+	if exitErr, ok := p.err.(*exec.ExitError); ok {
+		logEvent("exit", f, "[%s] %s\n", p.label, red(fmt.Sprintf("FAILED exit %d (%s)", exitErr.ExitCode(), took)))
+	} else {
+		f["error"] = p.err.Error()
+		logEvent("exit", f, "[%s] %s\n", p.label, red(fmt.Sprintf("FAILED: %v", p.err)))
+	}
+}
@@ -0,0 +1,125 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestRunner returns a runner for opts whose command records the
+// changed paths of each run as a line of the returned file, and then
+// runs the shell code in then.
+func newTestRunner(t *testing.T, opts *options, then string) (*runner, string) {
+	t.Helper()
+	log := filepath.Join(t.TempDir(), "runs")
+	opts.command = "echo $ON_CHANGE_FILES >> " + shellQuote(log) + then
+	opts.shell = "sh"
+	r := newRunner(opts, nil, nil, nil)
+	t.Cleanup(r.shutdown)
+	return r, log
+}
+
+// changeOf returns the change of files, as the scheduler passes it.
+func changeOf(files ...string) change {
+	return change{watched: []string{"test"}, files: files, last: files[len(files)-1], at: time.Now()}
+}
+
+func TestRunnerRestart(t *testing.T) {
+	opts := newOptions()
+	opts.restart = true
+	r, log := newTestRunner(t, opts, "; exec sleep 10")
+
+	start := time.Now()
+	r.run(changeOf("a"))
+	waitRuns(t, log, 1)
+	if running, _, _ := r.state(); !running {
+		t.Fatal("not running after the first change")
+	}
+	r.run(changeOf("b"))
+	if got, want := waitRuns(t, log, 2), []string{"a", "b"}; !slices.Equal(got, want) {
+		t.Errorf("runs = %q, want %q", got, want)
+	}
+	r.mu.Lock()
+	n := len(r.running)
+	r.mu.Unlock()
+	if n != 1 {
+		t.Errorf("%d instances running, want 1", n)
+	}
+
+	r.shutdown()
+	if running, _, _ := r.state(); running {
+		t.Error("still running after shutdown")
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("stopping took %s", took)
+	}
+}
+
+func TestRunnerTimeout(t *testing.T) {
+	tests := []struct {
+		then string
+		runs int
+		exit int
+	}{
+		{"", 1, 0},
+		{"; exit 3", 1, 3},
+		// Timed out, then retried twice
+		{"; sleep 10", 3, 1},
+	}
+	for _, tt := range tests {
+		opts := newOptions()
+		opts.timeout = 200 * time.Millisecond
+		opts.killTimeout = time.Second
+		opts.retries = 2
+		r, log := newTestRunner(t, opts, tt.then)
+
+		start := time.Now()
+		r.run(changeOf("a"))
+		if took := time.Since(start); took > 5*time.Second {
+			t.Errorf("%q: took %s", tt.then, took)
+		}
+		if n := len(runs(t, log)); n != tt.runs {
+			t.Errorf("%q: ran %d time(s), want %d", tt.then, n, tt.runs)
+		}
+		if code := r.exitStatus(); code != tt.exit {
+			t.Errorf("%q: exit status %d, want %d", tt.then, code, tt.exit)
+		}
+	}
+}
+
+func TestRunnerHookDir(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		docker, cwd, want string
+	}{
+		{"", "", wd},
+		{"", dir, dir},
+		// --cwd is in the container, the hook runs here
+		{"app", "/app", wd},
+	}
+	for _, tt := range tests {
+		opts := newOptions()
+		opts.docker, opts.cwd = tt.docker, tt.cwd
+		r, _ := newTestRunner(t, opts, "")
+		out := filepath.Join(t.TempDir(), "pwd")
+		r.hook("pwd > "+shellQuote(out), changeOf("a"), "test")
+		b, err := os.ReadFile(out)
+		if err != nil {
+			t.Errorf("--docker %q --cwd %q: hook did not run: %v", tt.docker, tt.cwd, err)
+			continue
+		}
+		got, _ := filepath.EvalSymlinks(strings.TrimSpace(string(b)))
+		if want, _ := filepath.EvalSymlinks(tt.want); got != want {
+			t.Errorf("--docker %q --cwd %q: hook ran in %s, want %s", tt.docker, tt.cwd, got, want)
+		}
+	}
+}
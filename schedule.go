@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	defaultDebounce = 100 * time.Millisecond
+	defaultThrottle = 500 * time.Millisecond
+)
+
+// scheduler collects changes, waits for them to settle and then runs
+// the command once for the whole batch.
+type scheduler struct {
+	run      *runner
+	watched  func() []string
+	hashes   *contentHashes // nil unless --hash
+	filter   *pathFilter
+	debounce durationRules
+	throttle durationRules
+	perFile  bool
+	jobs     int
+	cancel   bool
+	lock     *sync.Mutex // shared with the rules of the same lock, or nil
+
+	mu       sync.Mutex
+	timer    *time.Timer
+	changed  map[string]fsnotify.Op
+	last     string        // most recently changed path
+	first    time.Time     // when the first change of the batch came in
+	wait     time.Duration // longest debounce of the changed paths
+	lastExec time.Time
+	running  bool // the command is executing, changes wait for it
+	triggers int  // runs started by changes or by hand
+	signals  *runSignals
+
+	exitOnChange bool
+	canceled     bool // with --cancel-on-change, the running command was told to stop
+}
+
+// runSignals tell the main loop about runs, shared by the schedulers of
+// all rules.
+type runSignals struct {
+	ran      chan struct{} // signalled after each run triggered by a change
+	detected chan struct{} // with --exit-on-change, closed on the first change
+	once     sync.Once
+}
+
+func newRunSignals() *runSignals {
+	return &runSignals{ran: make(chan struct{}, 1), detected: make(chan struct{})}
+}
+
+func newScheduler(opts *options, filter *pathFilter, run *runner, watched func() []string, hashes *contentHashes, signals *runSignals) *scheduler {
+	return &scheduler{
+		run:      run,
+		watched:  watched,
+		hashes:   hashes,
+		filter:   filter,
+		debounce: opts.debounce,
+		throttle: opts.throttle,
+		perFile:  opts.perFile,
+		jobs:     opts.jobs,
+		cancel:   opts.cancelOnChange,
+		changed:  map[string]fsnotify.Op{},
+		signals:  signals,
+		lastExec: time.Now(),
+
+		exitOnChange: opts.exitOnChange,
+	}
+}
+
+// add records a change and restarts the debounce timer.
+func (s *scheduler) add(name string, op fsnotify.Op) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.changed) == 0 {
+		s.first = time.Now()
+	}
+	s.changed[name] |= op
+	s.last = name
+	if d := s.lookup(&s.debounce, name); d > s.wait {
+		s.wait = d
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+
+	// Debounce: wait for more changes before executing
+	debugf("%s: waiting %s for more changes", name, s.wait)
+	s.timer = time.AfterFunc(s.wait, s.fire)
+}
+
+// lookup returns the duration of the last rule matching name, or the
+// default.
+func (s *scheduler) lookup(r *durationRules, name string) time.Duration {
+	d := r.def
+	for _, rule := range r.rules {
+		if s.filter.matches(rule.pattern, name) {
+			d = rule.d
+		}
+	}
+	return d
+}
+
+func (s *scheduler) fire() {
+	s.mu.Lock()
+	if s.running {
+		// Picked up once the current run finishes
+		debugf("command still running, %d change(s) wait for it to finish", len(s.changed))
+		if s.cancel && !s.canceled {
+			s.canceled = true
+			go s.run.stopAll("Files changed, cancelling run")
+		}
+		s.mu.Unlock()
+		return
+	}
+
+	batch := s.changed
+	last := s.last
+	s.changed = map[string]fsnotify.Op{}
+	s.wait = 0
+
+	// Prevent executing too frequently, keeping the changes for the next
+	// allowed run
+	if wait := s.throttleOf(batch) - time.Since(s.lastExec); wait > 0 {
+		debugf("throttled, running in %s", wait.Round(time.Millisecond))
+		for name, op := range batch {
+			s.changed[name] |= op
+		}
+		s.last = last
+		s.timer = time.AfterFunc(wait, s.fire)
+		s.mu.Unlock()
+		return
+	}
+
+	if s.hashes != nil && !s.hashes.changed(sortedKeys(batch)) {
+		logf("[%s] Content unchanged, skipping\n", filepath.Base(last))
+		s.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	clearBeforeRun()
+	ops := map[string]string{}
+	for name, op := range batch {
+		ops[name] = op.String()
+	}
+	logEvent("change", fields{"files": sortedKeys(batch), "last": last, "op": batch[last].String(), "ops": ops},
+		"[%s] Change detected at %s: %s\n", filepath.Base(last), formatTime(now), describeBatch(batch))
+	if s.exitOnChange {
+		// Leave it to whoever runs on_change in a loop
+		s.mu.Unlock()
+		s.signals.once.Do(func() { close(s.signals.detected) })
+		return
+	}
+	s.running = true
+	s.triggers++
+	s.lastExec = now
+	metrics.debounced(now.Sub(s.first))
+	s.mu.Unlock()
+
+	files := sortedKeys(batch)
+	s.acquire()
+	if s.perFile {
+		s.runEach(files, batch, now)
+	} else {
+		s.run.run(change{watched: s.watched(), files: files, last: last, ops: batch, at: now})
+	}
+	s.release()
+	s.finish()
+}
+
+// force runs the command right away, without waiting for a change. It
+// does nothing while the command is running.
+func (s *scheduler) force() {
+	s.mu.Lock()
+	if s.running || s.exitOnChange {
+		s.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	clearBeforeRun()
+	logf("Re-running at %s\n", formatTime(now))
+	s.running = true
+	s.triggers++
+	s.lastExec = now
+	s.mu.Unlock()
+
+	s.acquire()
+	s.run.run(initialChange(s.watched()))
+	s.release()
+	s.finish()
+}
+
+// triggerCount returns how often the command ran for changes or by
+// hand, not counting the initial run.
+func (s *scheduler) triggerCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.triggers
+}
+
+// acquire waits until no other rule holding the same lock runs.
+func (s *scheduler) acquire() {
+	if s.lock != nil {
+		s.lock.Lock()
+	}
+}
+
+func (s *scheduler) release() {
+	if s.lock != nil {
+		s.lock.Unlock()
+	}
+}
+
+// finish is called after every run and schedules a follow-up run for
+// changes that came in meanwhile.
+func (s *scheduler) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = false
+	s.canceled = false
+	select {
+	case s.signals.ran <- struct{}{}:
+	default:
+	}
+	if len(s.changed) > 0 {
+		// Files changed while the command ran, so its result is already
+		// stale: run once more, as soon as the throttle allows.
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+		s.timer = time.AfterFunc(s.throttleOf(s.changed)-time.Since(s.lastExec), s.fire)
+	}
+}
+
+// throttleOf returns the longest throttle of the paths in batch.
+func (s *scheduler) throttleOf(batch map[string]fsnotify.Op) time.Duration {
+	throttle := time.Duration(0)
+	for name := range batch {
+		if d := s.lookup(&s.throttle, name); d > throttle {
+			throttle = d
+		}
+	}
+	return throttle
+}
+
+// runEach runs the command for every file, at most s.jobs at a time,
+// and waits for all of them.
+func (s *scheduler) runEach(files []string, batch map[string]fsnotify.Op, now time.Time) {
+	watched := s.watched()
+	sem := make(chan struct{}, max(s.jobs, 1))
+	var wg sync.WaitGroup
+	for _, f := range files {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			s.run.run(change{watched: watched, files: []string{f}, last: f, ops: batch, at: now})
+		}()
+	}
+	wg.Wait()
+}
+
+// maxDescribed is how many changed paths the trigger message names.
+const maxDescribed = 5
+
+// describeBatch lists the changed paths with their operations, as in
+// "a.go (WRITE), b.go (CREATE|WRITE) and 3 more".
+func describeBatch(batch map[string]fsnotify.Op) string {
+	files := sortedKeys(batch)
+	var parts []string
+	for _, name := range files[:min(len(files), maxDescribed)] {
+		parts = append(parts, fmt.Sprintf("%s (%s)", name, batch[name]))
+	}
+	desc := strings.Join(parts, ", ")
+	if len(files) > maxDescribed {
+		desc += fmt.Sprintf(" and %d more", len(files)-maxDescribed)
+	}
+	return desc
+}
+
+// sortedKeys returns the changed paths of a batch in a stable order.
+func sortedKeys(batch map[string]fsnotify.Op) []string {
+	keys := make([]string, 0, len(batch))
+	for k := range batch {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,164 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestScheduler returns a scheduler for opts whose command records
+// the changed paths of each run as a line of the returned file, and
+// then runs the shell code in then.
+func newTestScheduler(t *testing.T, opts *options, then string) (*scheduler, *runSignals, string) {
+	t.Helper()
+	log := filepath.Join(t.TempDir(), "runs")
+	opts.command = "echo $ON_CHANGE_FILES >> " + shellQuote(log) + then
+	opts.shell = "sh"
+	signals := newRunSignals()
+	run := newRunner(opts, nil, nil, nil)
+	t.Cleanup(run.shutdown)
+	watched := func() []string { return []string{"test"} }
+	return newScheduler(opts, newPathFilter(opts), run, watched, nil, signals), signals, log
+}
+
+// runs returns the changed paths of the runs recorded in log.
+func runs(t *testing.T, log string) []string {
+	t.Helper()
+	b, err := os.ReadFile(log)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+}
+
+// waitRuns waits for n runs to be recorded in log and returns them.
+func waitRuns(t *testing.T, log string, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := runs(t, log)
+		if len(got) >= n || time.Now().After(deadline) {
+			return got
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSchedulerDebounce(t *testing.T) {
+	opts := newOptions()
+	opts.debounce = durationRules{def: 100 * time.Millisecond}
+	opts.throttle = durationRules{}
+	s, _, log := newTestScheduler(t, opts, "")
+
+	for _, name := range []string{"b", "a", "c", "a"} {
+		s.add(name, 0)
+		time.Sleep(20 * time.Millisecond)
+	}
+	waitRuns(t, log, 1)
+	// Nothing left over for a second run
+	time.Sleep(300 * time.Millisecond)
+	if got, want := runs(t, log), []string{"a b c"}; !slices.Equal(got, want) {
+		t.Errorf("runs = %q, want %q", got, want)
+	}
+}
+
+func TestSchedulerLookup(t *testing.T) {
+	opts := newOptions()
+	for _, v := range []string{"data/**=5s", "*.md=0s"} {
+		if err := opts.debounce.Set(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := newScheduler(opts, newPathFilter(opts), nil, nil, nil, newRunSignals())
+	tests := []struct {
+		name string
+		want time.Duration
+	}{
+		{"main.go", defaultDebounce},
+		{"data/a/b.csv", 5 * time.Second},
+		{"docs/README.md", 0},
+		// The last matching pattern wins
+		{"data/notes.md", 0},
+	}
+	for _, tt := range tests {
+		if got := s.lookup(&s.debounce, tt.name); got != tt.want {
+			t.Errorf("lookup(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSchedulerThrottle(t *testing.T) {
+	const throttle = 300 * time.Millisecond
+	opts := newOptions()
+	opts.debounce = durationRules{def: 10 * time.Millisecond}
+	opts.throttle = durationRules{def: throttle}
+	s, signals, log := newTestScheduler(t, opts, "")
+
+	s.add("a", 0)
+	<-signals.ran
+	first := time.Now()
+	s.add("b", 0)
+	time.Sleep(50 * time.Millisecond)
+	s.add("c", 0)
+	<-signals.ran
+	// Less the time the first run took
+	if took := time.Since(first); took < throttle-50*time.Millisecond {
+		t.Errorf("second run %s after the first, want at least %s", took, throttle)
+	}
+	if got, want := runs(t, log), []string{"a", "b c"}; !slices.Equal(got, want) {
+		t.Errorf("runs = %q, want %q", got, want)
+	}
+}
+
+func TestSchedulerFollowUp(t *testing.T) {
+	opts := newOptions()
+	opts.debounce = durationRules{def: 10 * time.Millisecond}
+	opts.throttle = durationRules{}
+	s, _, log := newTestScheduler(t, opts, "; sleep 0.3")
+
+	s.add("a", 0)
+	waitRuns(t, log, 1)
+	// Come in while a runs and are run together once it is done
+	s.add("b", 0)
+	s.add("c", 0)
+	waitRuns(t, log, 2)
+	time.Sleep(500 * time.Millisecond)
+	if got, want := runs(t, log), []string{"a", "b c"}; !slices.Equal(got, want) {
+		t.Errorf("runs = %q, want %q", got, want)
+	}
+}
+
+func TestSchedulerCancelOnChange(t *testing.T) {
+	opts := newOptions()
+	opts.debounce = durationRules{def: 10 * time.Millisecond}
+	opts.throttle = durationRules{}
+	opts.cancelOnChange = true
+	s, _, log := newTestScheduler(t, opts, `; [ "$ON_CHANGE_FILE" != a ] || sleep 10`)
+
+	start := time.Now()
+	s.add("a", 0)
+	waitRuns(t, log, 1)
+	s.add("b", 0)
+	waitRuns(t, log, 2)
+	for s.run.timings().n == 0 && time.Since(start) < 5*time.Second {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("the run of a was not cancelled, b ran after %s", took)
+	}
+	if got, want := runs(t, log), []string{"a", "b"}; !slices.Equal(got, want) {
+		t.Errorf("runs = %q, want %q", got, want)
+	}
+	// The cancelled run does not count
+	if n := s.run.timings().n; n != 1 {
+		t.Errorf("%d finished run(s), want 1", n)
+	}
+}
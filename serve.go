@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const defaultServeAddr = "localhost:8000"
+
+// serveArgs turns "serve DIR [flags] --on PATTERNS -- COMMAND" into
+// the flags of a normal run: DIR is served with --serve and the
+// comma-separated patterns of --on are watched. DIR itself is not
+// watched by default, it is where the command writes the site, and
+// every build would trigger the next one.
+func serveArgs(args []string) ([]string, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return nil, fmt.Errorf("usage: %s serve DIR [flags] --on PATTERNS -- COMMAND", filepath.Base(os.Args[0]))
+	}
+	out := []string{"--serve", args[0]}
+	var patterns []string
+	rest := args[1:]
+	for i := 0; i < len(rest); i++ {
+		arg := rest[i]
+		if arg == "--" {
+			if len(patterns) == 0 {
+				break
+			}
+			out = append(out, patterns...)
+			return append(out, rest[i:]...), nil
+		}
+		value, ok := strings.CutPrefix(arg, "--on=")
+		if !ok && arg == "--on" {
+			if i+1 == len(rest) {
+				return nil, fmt.Errorf("--on needs patterns, as '*.html,*.css'")
+			}
+			i++
+			value, ok = rest[i], true
+		}
+		if !ok {
+			out = append(out, arg)
+			continue
+		}
+		for _, p := range strings.Split(value, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				patterns = append(patterns, p)
+			}
+		}
+	}
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("serve needs --on with the sources to watch, as 'src/*.md,*.css'")
+	}
+	return append(out, patterns...), nil
+}
+
+// staticServer serves a directory over HTTP for --serve, with a script
+// in every HTML page reloading it after successful runs.
+type staticServer struct {
+	srv *http.Server
+	dir string
+}
+
+func startStaticServer(addr, dir string, reload *liveReload) (*staticServer, error) {
+	if !isDir(dir) {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &staticServer{dir: dir}
+	mux := http.NewServeMux()
+	reload.register(mux)
+	mux.Handle("/", s)
+	s.srv = &http.Server{Handler: mux}
+	go s.srv.Serve(ln)
+	return s, nil
+}
+
+func (s *staticServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Always serve the latest build
+	w.Header().Set("Cache-Control", "no-store")
+	name := path.Clean("/" + r.URL.Path)
+	file := filepath.Join(s.dir, filepath.FromSlash(name))
+	if isDir(file) {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+			return
+		}
+		file = filepath.Join(file, "index.html")
+	}
+	ext := strings.ToLower(filepath.Ext(file))
+	if ext != ".html" && ext != ".htm" {
+		http.FileServer(http.Dir(s.dir)).ServeHTTP(w, r)
+		return
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		// Directory listings and 404s
+		http.FileServer(http.Dir(s.dir)).ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(injectReload(b))
+}
+
+// injectReload adds the reload script before the end of the body.
+func injectReload(page []byte) []byte {
+	script := []byte(`<script src="/livereload.js"></script>`)
+	i := bytes.LastIndex(bytes.ToLower(page), []byte("</body>"))
+	if i < 0 {
+		return append(page, script...)
+	}
+	return append(page[:i:i], append(script, page[i:]...)...)
+}
+
+func (s *staticServer) Close() error {
+	return s.srv.Close()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestServeArgs(t *testing.T) {
+	tests := []struct {
+		args, want []string
+	}{
+		{
+			[]string{"public", "--on", "src/*.md,*.css", "--", "make"},
+			[]string{"--serve", "public", "src/*.md", "*.css", "--", "make"},
+		},
+		{
+			[]string{"public", "--on=*.md", "--debounce", "1s", "--", "make", "site"},
+			[]string{"--serve", "public", "--debounce", "1s", "*.md", "--", "make", "site"},
+		},
+		// Repeated and padded patterns, the command given by a config file
+		{
+			[]string{"public", "--on", " a.md , ", "--on", "b.md"},
+			[]string{"--serve", "public", "a.md", "b.md"},
+		},
+	}
+	for _, tt := range tests {
+		got, err := serveArgs(tt.args)
+		if err != nil {
+			t.Errorf("serveArgs(%q): %v", tt.args, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("serveArgs(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestServeArgsErrors(t *testing.T) {
+	tests := [][]string{
+		nil,
+		{"--on", "*.md"},
+		{"public", "--", "make"},
+		{"public", "--on"},
+		{"public", "--on", ",", "--", "make"},
+		// --on after -- is part of the command
+		{"public", "--", "make", "--on", "*.md"},
+	}
+	for _, args := range tests {
+		if got, err := serveArgs(args); err == nil {
+			t.Errorf("serveArgs(%q) = %q, want an error", args, got)
+		}
+	}
+}
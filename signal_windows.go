@@ -0,0 +1,13 @@
+//go:build windows
+
+package main
+
+import "syscall"
+
+// signalNames are the signals --signal accepts, without the SIG prefix.
+// Windows can only really deliver a kill, the others fall back to it.
+var signalNames = map[string]syscall.Signal{
+	"INT":  syscall.SIGINT,
+	"KILL": syscall.SIGKILL,
+	"TERM": syscall.SIGTERM,
+}
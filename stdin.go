@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io"
+	"sync"
+)
+
+// stdinForwarder copies our stdin to whichever command is currently
+// running, for --forward-stdin. Input arriving while nothing runs is
+// dropped.
+type stdinForwarder struct {
+	mu  sync.Mutex
+	w   io.WriteCloser
+	eof bool
+}
+
+func newStdinForwarder(r io.Reader) *stdinForwarder {
+	f := &stdinForwarder{}
+	go f.copy(r)
+	return f
+}
+
+func (f *stdinForwarder) copy(r io.Reader) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		f.mu.Lock()
+		if n > 0 && f.w != nil {
+			f.w.Write(buf[:n])
+		}
+		if err != nil {
+			f.eof = true
+			if f.w != nil {
+				f.w.Close()
+			}
+			f.mu.Unlock()
+			return
+		}
+		f.mu.Unlock()
+	}
+}
+
+// attach sends further input to w, the stdin of a new command.
+func (f *stdinForwarder) attach(w io.WriteCloser) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.w = w
+	if f.eof {
+		w.Close()
+	}
+}
+
+// detach stops sending input to w once its command exited.
+func (f *stdinForwarder) detach(w io.WriteCloser) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.w == w {
+		f.w = nil
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sdNotify tells systemd about the service's state, as in "READY=1",
+// when on_change runs as a Type=notify unit. Otherwise it does nothing.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if socket[0] == '@' {
+		// Abstract socket
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		debugf("sd_notify: %v", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		debugf("sd_notify: %v", err)
+	}
+}
+
+// watchdogInterval returns how often to ping systemd's watchdog, half of
+// WatchdogSec=, or 0 if it is not enabled for us.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// pingWatchdog tells systemd every interval that we are alive, until
+// stop is closed.
+func pingWatchdog(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			sdNotify("WATCHDOG=1")
+		case <-stop:
+			return
+		}
+	}
+}
+
+// journal sends on_change's messages to the systemd journal with their
+// details as fields, for --journal. It is nil without the flag.
+var journal *journalWriter
+
+const journalSocket = "/run/systemd/journal/socket"
+
+type journalWriter struct {
+	conn *net.UnixConn
+}
+
+func openJournal() (*journalWriter, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journalWriter{conn: conn}, nil
+}
+
+// write sends an event as a journal entry using the native protocol:
+// MESSAGE, a PRIORITY, ON_CHANGE_EVENT and every field of f as
+// ON_CHANGE_<FIELD>.
+func (j *journalWriter) write(kind string, f fields, priority int) {
+	if j == nil {
+		return
+	}
+	var b bytes.Buffer
+	msg, _ := f["message"].(string)
+	journalField(&b, "MESSAGE", ansiCodes.ReplaceAllString(msg, ""))
+	journalField(&b, "PRIORITY", strconv.Itoa(priority))
+	journalField(&b, "SYSLOG_IDENTIFIER", "on_change")
+	journalField(&b, "ON_CHANGE_EVENT", kind)
+	for k, v := range f {
+		if k == "message" {
+			continue
+		}
+		value, ok := v.(string)
+		if !ok {
+			encoded, _ := json.Marshal(v)
+			value = string(encoded)
+		}
+		journalField(&b, "ON_CHANGE_"+journalName(k), value)
+	}
+	if _, err := j.conn.Write(b.Bytes()); err != nil {
+		warnf("Warning: journal: %v\n", err)
+	}
+}
+
+// journalField appends KEY=value, or the binary form for values
+// spanning lines.
+func journalField(b *bytes.Buffer, key, value string) {
+	if !strings.Contains(value, "\n") {
+		b.WriteString(key + "=" + value + "\n")
+		return
+	}
+	b.WriteString(key + "\n")
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
+
+// journalName turns a field name into a journal field name: upper case
+// letters, digits and underscores.
+func journalName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func (j *journalWriter) Close() error {
+	return j.conn.Close()
+}
@@ -0,0 +1,10 @@
+//go:build linux || solaris || illumos || aix
+
+package main
+
+import "golang.org/x/sys/unix"
+
+const (
+	ioctlGetTermios = unix.TCGETS
+	ioctlSetTermios = unix.TCSETS
+)
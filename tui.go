@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// screen is the dashboard of --tui, nil otherwise. While it is shown,
+// on_change's messages and the command's output are drawn into its
+// panes instead of being printed.
+var screen *dashboard
+
+// terminalCodes are the escapes stripped from output shown in a pane.
+var terminalCodes = regexp.MustCompile("\033\\[[0-9;?]*[A-Za-z]|\033\\][^\a]*\a")
+
+const (
+	maxOutputLines = 1000
+	maxEvents      = 100
+	maxRuns        = 100
+)
+
+// dashboard is the full-screen terminal UI of --tui, with panes for the
+// watched paths, recent events, the run history and the output of the
+// command.
+type dashboard struct {
+	tty     *os.File
+	restore *term.State
+	watched func() []string
+	keys    chan string
+
+	mu      sync.Mutex
+	events  []string
+	runs    []string
+	output  []string
+	partial string // output after the last newline
+	paused  bool
+	dirty   bool
+	done    chan struct{}
+}
+
+// startDashboard switches the terminal to the dashboard and returns it.
+// Keys are read from tty, which is put into raw mode.
+func startDashboard(tty *os.File, watched func() []string) (*dashboard, error) {
+	if !isTerminal(os.Stdout) {
+		return nil, fmt.Errorf("--tui needs a terminal")
+	}
+	state, err := term.MakeRaw(int(tty.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	d := &dashboard{
+		tty:     tty,
+		restore: state,
+		watched: watched,
+		keys:    make(chan string),
+		dirty:   true,
+		done:    make(chan struct{}),
+	}
+	// Alternate screen, hidden cursor
+	logMu.Lock()
+	os.Stdout.WriteString("\033[?1049h\033[?25l")
+	screen = d
+	logMu.Unlock()
+	go d.readKeys()
+	go d.refresh()
+	return d, nil
+}
+
+// shownDashboard returns the dashboard, if it is shown.
+func shownDashboard() *dashboard {
+	logMu.Lock()
+	defer logMu.Unlock()
+	return screen
+}
+
+// close restores the terminal.
+func (d *dashboard) close() {
+	close(d.done)
+	logMu.Lock()
+	screen = nil
+	os.Stdout.WriteString("\033[?25h\033[?1049l")
+	logMu.Unlock()
+	term.Restore(int(d.tty.Fd()), d.restore)
+}
+
+// readKeys turns key presses into the commands of the main loop, see
+// keyCommand.
+func (d *dashboard) readKeys() {
+	buf := make([]byte, 16)
+	for {
+		n, err := d.tty.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, b := range buf[:n] {
+			key := keyCommand(b)
+			if b == 3 { // Ctrl+C does not interrupt in raw mode
+				key = "q"
+			}
+			if key == "" {
+				continue
+			}
+			select {
+			case d.keys <- key:
+			case <-d.done:
+				return
+			}
+		}
+	}
+}
+
+// refresh redraws the screen when something changed, at most 20 times a
+// second.
+func (d *dashboard) refresh() {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			d.mu.Lock()
+			dirty := d.dirty
+			d.dirty = false
+			d.mu.Unlock()
+			if dirty {
+				d.draw()
+			}
+		}
+	}
+}
+
+// event shows one of on_change's messages; finished runs go to the run
+// history.
+func (d *dashboard) event(kind string, f fields, msg string) {
+	msg = terminalCodes.ReplaceAllString(msg, "")
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	line := time.Now().Format("15:04:05") + " " + strings.ReplaceAll(msg, "\n", " ")
+	if kind == "exit" {
+		if code, _ := f["exit_code"].(int); code != 0 {
+			line = red(line)
+		} else {
+			line = green(line)
+		}
+		d.runs = appendLimited(d.runs, maxRuns, line)
+	} else {
+		if kind == "run" && d.partial != "" {
+			// Output of the next run starts on a line of its own
+			d.output = appendLimited(d.output, maxOutputLines, d.partial)
+			d.partial = ""
+		}
+		d.events = appendLimited(d.events, maxEvents, line)
+	}
+	d.dirty = true
+}
+
+// write adds the command's output to the output pane.
+func (d *dashboard) write(b []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	text := terminalCodes.ReplaceAllString(d.partial+string(b), "")
+	lines := strings.Split(text, "\n")
+	for _, line := range lines[:len(lines)-1] {
+		// A progress bar redraws its line after a carriage return
+		if i := strings.LastIndexByte(strings.TrimRight(line, "\r"), '\r'); i >= 0 {
+			line = line[i+1:]
+		}
+		d.output = appendLimited(d.output, maxOutputLines, strings.TrimRight(line, "\r"))
+	}
+	d.partial = lines[len(lines)-1]
+	d.dirty = true
+}
+
+// clearOutput empties the output pane.
+func (d *dashboard) clearOutput() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.output, d.partial = nil, ""
+	d.dirty = true
+}
+
+// setPaused shows whether watching is paused.
+func (d *dashboard) setPaused(paused bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.paused = paused
+	d.dirty = true
+}
+
+// draw paints the whole screen: the watched paths and the events on the
+// left, the run history and the output on the right.
+func (d *dashboard) draw() {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width < 20 || height < 8 {
+		return
+	}
+	watched := d.watched()
+
+	d.mu.Lock()
+	left := min(40, width/3)
+	right := width - left - 1
+	body := height - 2
+	pathRows := min(len(watched)+1, body/2)
+	runRows := min(len(d.runs)+1, body/3)
+	runRows = max(runRows, 2)
+
+	leftCol := pane("Watched", watched, pathRows, left)
+	leftCol = append(leftCol, pane("Events", d.events, body-pathRows, left)...)
+	output := d.output
+	if d.partial != "" {
+		output = append(output[:len(output):len(output)], d.partial)
+	}
+	rightCol := pane("Runs", d.runs, runRows, right)
+	rightCol = append(rightCol, pane("Output", output, body-runRows, right)...)
+
+	state := "watching"
+	if d.paused {
+		state = "paused"
+	}
+	d.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("\033[H")
+	b.WriteString("\033[7m" + fit(fmt.Sprintf(" on_change: %s %d path(s)", state, len(watched)), width) + "\033[0m\r\n")
+	for i := range body {
+		b.WriteString(leftCol[i] + "\033[0m│" + rightCol[i] + "\033[0m\r\n")
+	}
+	b.WriteString("\033[7m" + fit(" r re-run  p pause  c clear  t timings  q quit", width) + "\033[0m")
+
+	logMu.Lock()
+	defer logMu.Unlock()
+	if screen == d {
+		os.Stdout.WriteString(b.String())
+	}
+}
+
+// pane returns rows lines of exactly width columns: a title and the last
+// lines that fit.
+func pane(title string, lines []string, rows, width int) []string {
+	if rows <= 0 {
+		return nil
+	}
+	out := []string{"\033[1m" + fit(title, width) + "\033[0m"}
+	lines = lines[max(0, len(lines)-(rows-1)):]
+	for _, line := range lines {
+		out = append(out, fitColored(line, width))
+	}
+	for len(out) < rows {
+		out = append(out, strings.Repeat(" ", width))
+	}
+	return out
+}
+
+// fit cuts or pads s to width columns.
+func fit(s string, width int) string {
+	r := []rune(strings.ReplaceAll(s, "\t", "    "))
+	if len(r) > width {
+		return string(r[:width])
+	}
+	return string(r) + strings.Repeat(" ", width-len(r))
+}
+
+// fitColored is fit for a line painted as a whole by green or red.
+func fitColored(s string, width int) string {
+	plain := terminalCodes.ReplaceAllString(s, "")
+	if plain == s {
+		return fit(s, width)
+	}
+	return s[:strings.Index(s, plain)] + fit(plain, width) + "\033[0m"
+}
+
+// appendLimited appends line, dropping the oldest lines beyond limit.
+func appendLimited(lines []string, limit int, line string) []string {
+	lines = append(lines, line)
+	if len(lines) > limit {
+		lines = append(lines[:0], lines[len(lines)-limit:]...)
+	}
+	return lines
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// urlSource is an HTTP(S) resource. It is fetched with conditional GETs
+// using the ETag and Last-Modified validators the server hands out, and
+// its version is the checksum of the body, so servers without
+// validators work too.
+type urlSource struct {
+	url    string
+	client *http.Client
+
+	etag         string
+	lastModified string
+	version      string
+}
+
+// isURL reports whether a watched path is really an HTTP(S) URL.
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+func newURLSource(url string) *urlSource {
+	return &urlSource{
+		url:    url,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (u *urlSource) String() string {
+	return u.url
+}
+
+func (u *urlSource) check() (map[string]string, error) {
+	req, err := http.NewRequest(http.MethodGet, u.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if u.etag != "" {
+		req.Header.Set("If-None-Match", u.etag)
+	}
+	if u.lastModified != "" {
+		req.Header.Set("If-Modified-Since", u.lastModified)
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && u.version != "":
+		return map[string]string{u.url: u.version}, nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		// reported as removed
+		u.etag, u.lastModified, u.version = "", "", ""
+		return map[string]string{}, nil
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, resp.Body); err != nil {
+		return nil, err
+	}
+	u.etag = resp.Header.Get("ETag")
+	u.lastModified = resp.Header.Get("Last-Modified")
+	u.version = hex.EncodeToString(hash.Sum(nil))
+	return map[string]string{u.url: u.version}, nil
+}
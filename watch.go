@@ -0,0 +1,731 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchSet tracks what the user asked to watch and decides which
+// fsnotify events are relevant. fsnotify only knows about individual
+// files and directories, so globs and recursive roots are resolved here.
+type watchSet struct {
+	watcher   notifier
+	recursive bool
+	follow    bool       // resolve symlinks and follow them when swapped
+	hidden    bool       // include dotfiles when recursing
+	ignore    *gitIgnore // nil unless .gitignore files are honoured
+	filter    *pathFilter
+
+	mu       sync.Mutex
+	explicit map[string]bool // files named on the command line
+	matched  map[string]bool // files currently matching one of the globs
+	dirs     map[string]bool // directories watched for their direct children
+	roots    []string        // recursive roots
+	globs    []string
+	deep     []string          // static prefixes of "**" globs, watched recursively
+	pending  map[string]bool   // named paths that do not exist yet
+	links    map[string]string // followed symlinks and their current target
+	watched  map[string]bool   // every path registered with the notifier
+	failures int               // paths the notifier refused to watch
+
+	maxDepth   int // 0 means unlimited
+	maxWatches int // 0 means unlimited
+	overLimit  int // paths not watched because of maxWatches
+}
+
+var errWatchLimit = errors.New("--max-watches limit reached")
+
+func newWatchSet(watcher notifier, opts *options) *watchSet {
+	ws := &watchSet{
+		watcher:   watcher,
+		recursive: opts.recursive,
+		follow:    opts.followSymlinks,
+		hidden:    opts.hidden,
+		explicit:  map[string]bool{},
+		matched:   map[string]bool{},
+		dirs:      map[string]bool{},
+		pending:   map[string]bool{},
+		links:     map[string]string{},
+		watched:   map[string]bool{},
+		filter:    newPathFilter(opts),
+
+		maxDepth:   opts.maxDepth,
+		maxWatches: opts.maxWatches,
+	}
+	if opts.recursive && !opts.noGitignore {
+		ws.ignore = newGitIgnore()
+	}
+	return ws
+}
+
+// add registers a path or glob pattern. Glob patterns are kept around
+// and their directories watched, so files created later that match the
+// pattern are picked up too.
+func (ws *watchSet) add(pattern string) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	pattern = filepath.Clean(pattern)
+	if hasMeta(pattern) {
+		return ws.addGlob(pattern)
+	}
+
+	info, err := os.Stat(pattern)
+	if os.IsNotExist(err) {
+		return ws.addPending(pattern)
+	}
+	if err != nil {
+		return err
+	}
+	if ws.follow {
+		if err := ws.addLink(pattern); err != nil {
+			return err
+		}
+	}
+	if info.IsDir() {
+		ws.addDir(pattern)
+		return nil
+	}
+	return ws.addFile(pattern)
+}
+
+// addFile watches a single file through its parent directory and
+// matches events by name, so editors that save by writing a temporary
+// file and renaming it over the original keep triggering.
+func (ws *watchSet) addFile(name string) error {
+	if err := ws.watch(filepath.Dir(name)); err != nil {
+		return err
+	}
+	if _, ok := ws.links[name]; ok {
+		// the directory only sees the link itself, not its target
+		if err := ws.watch(name); err != nil {
+			return err
+		}
+	}
+	ws.explicit[name] = true
+	return nil
+}
+
+// remove stops watching a path or pattern previously passed to add and
+// drops the watches nothing else needs anymore.
+func (ws *watchSet) remove(pattern string) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	pattern = filepath.Clean(pattern)
+	found := ws.explicit[pattern] || ws.dirs[pattern] || ws.pending[pattern]
+	delete(ws.explicit, pattern)
+	delete(ws.dirs, pattern)
+	delete(ws.pending, pattern)
+	delete(ws.links, pattern)
+
+	var roots []string
+	for _, r := range ws.roots {
+		if r == pattern {
+			found = true
+			continue
+		}
+		roots = append(roots, r)
+	}
+	ws.roots = roots
+
+	var globs, deep []string
+	for _, g := range ws.globs {
+		if g == pattern {
+			found = true
+			continue
+		}
+		globs = append(globs, g)
+		if strings.Contains(g, "**") {
+			deep = append(deep, staticPrefix(g))
+		}
+	}
+	ws.globs, ws.deep = globs, deep
+	for m := range ws.matched {
+		if !ws.matchesGlob(m) {
+			delete(ws.matched, m)
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("not watched")
+	}
+	for p := range ws.watched {
+		if !ws.needed(p) {
+			ws.watcher.Remove(p)
+			delete(ws.watched, p)
+		}
+	}
+	return nil
+}
+
+// needed reports whether a registered watch still serves any of the
+// watched files, directories, patterns or symlinks.
+func (ws *watchSet) needed(path string) bool {
+	if ws.dirs[path] {
+		return true
+	}
+	for f := range ws.explicit {
+		if f == path || filepath.Dir(f) == path {
+			return true
+		}
+	}
+	for l := range ws.links {
+		if l == path || filepath.Dir(l) == path {
+			return true
+		}
+	}
+	for p := range ws.pending {
+		if existingAncestor(p) == path {
+			return true
+		}
+	}
+	for _, r := range ws.roots {
+		if within(r, path) {
+			return true
+		}
+	}
+	for _, b := range ws.deep {
+		if within(b, path) {
+			return true
+		}
+	}
+	for _, g := range ws.globs {
+		if ok, _ := filepath.Match(filepath.Dir(g), path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// addPending waits for name to appear by watching its closest existing
+// ancestor directory.
+func (ws *watchSet) addPending(name string) error {
+	if err := ws.watch(existingAncestor(name)); err != nil {
+		return err
+	}
+	if !ws.pending[name] {
+		logf("Waiting for %s to appear\n", name)
+	}
+	ws.pending[name] = true
+	return nil
+}
+
+// resolvePending watches name if it exists by now and reports whether
+// it did, otherwise it moves the ancestor watch closer to name.
+func (ws *watchSet) resolvePending(name string) bool {
+	info, err := os.Stat(name)
+	if err != nil {
+		ws.watch(existingAncestor(name))
+		return false
+	}
+	delete(ws.pending, name)
+	if info.IsDir() {
+		ws.addDir(name)
+		return true
+	}
+	if err := ws.addFile(name); err != nil {
+		warnf("Error watching '%s': %v\n", name, err)
+	}
+	return true
+}
+
+// addLink starts following name if it is a symlink: its parent
+// directory is watched so that replacing the link is noticed.
+func (ws *watchSet) addLink(name string) error {
+	info, err := os.Lstat(name)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return err
+	}
+	target, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return err
+	}
+	if err := ws.watch(filepath.Dir(name)); err != nil {
+		return err
+	}
+	ws.links[name] = target
+	logf("Following symlink %s -> %s\n", name, target)
+	return nil
+}
+
+// relink re-resolves a followed symlink and moves its watches over to
+// the new target when the link was swapped.
+func (ws *watchSet) relink(name string) {
+	target, err := filepath.EvalSymlinks(name)
+	if err != nil || target == ws.links[name] {
+		return
+	}
+	logf("Symlink %s now points to %s\n", name, target)
+	ws.links[name] = target
+
+	for p := range ws.watched {
+		if within(name, p) {
+			ws.watcher.Remove(p)
+			delete(ws.watched, p)
+		}
+	}
+	if ws.recursive && isDir(name) {
+		ws.addTree(name)
+	} else if err := ws.watch(name); err != nil {
+		warnf("Error watching '%s': %v\n", name, err)
+	}
+}
+
+func (ws *watchSet) addGlob(pattern string) error {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return err
+	}
+	if strings.Contains(pattern, "**") {
+		return ws.addDoublestar(pattern)
+	}
+	parents, _ := filepath.Glob(filepath.Dir(pattern))
+	watching := 0
+	for _, dir := range parents {
+		if !isDir(dir) {
+			continue
+		}
+		if err := ws.watch(dir); err != nil {
+			warnf("Error watching '%s': %v\n", dir, err)
+			continue
+		}
+		watching++
+	}
+	if watching == 0 {
+		return fmt.Errorf("no directory to watch for pattern")
+	}
+	ws.globs = append(ws.globs, pattern)
+
+	matches, _ := filepath.Glob(pattern)
+	for _, m := range matches {
+		if ws.filter.excluded(m) {
+			continue
+		}
+		if isDir(m) {
+			ws.addDir(m)
+		} else {
+			ws.matched[m] = true
+		}
+	}
+	return nil
+}
+
+// addDoublestar registers a pattern containing "**", which can match at
+// any depth below its static prefix, so that whole tree is watched.
+func (ws *watchSet) addDoublestar(pattern string) error {
+	base := staticPrefix(pattern)
+	if !isDir(base) {
+		return fmt.Errorf("no directory to watch for pattern")
+	}
+	ws.globs = append(ws.globs, pattern)
+	ws.deep = append(ws.deep, base)
+	ws.addTree(base)
+
+	filepath.WalkDir(base, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != base && (ws.filter.excluded(path) || !ws.hidden && isHidden(d.Name())) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ws.matchesGlob(path) && !ws.filter.excluded(path) {
+			ws.matched[path] = true
+		}
+		return nil
+	})
+	return nil
+}
+
+func (ws *watchSet) addDir(dir string) {
+	if ws.recursive {
+		if ws.ignore != nil {
+			ws.ignore.addRoot(dir)
+		}
+		ws.roots = append(ws.roots, dir)
+		n := ws.addTree(dir)
+		logf("Watching %d director(ies) under %s\n", n, dir)
+		return
+	}
+	if err := ws.watch(dir); err != nil {
+		warnf("Error watching '%s': %v\n", dir, err)
+		return
+	}
+	ws.dirs[dir] = true
+}
+
+// watch registers name with the notifier, counting failures so the
+// caller can suggest the polling backend.
+func (ws *watchSet) watch(name string) error {
+	if ws.watched[name] {
+		return nil
+	}
+	if ws.maxWatches > 0 && len(ws.watched) >= ws.maxWatches {
+		ws.overLimit++
+		return errWatchLimit
+	}
+	err := ws.watcher.Add(name)
+	if err != nil {
+		ws.failures++
+		return err
+	}
+	ws.watched[name] = true
+	return nil
+}
+
+// watchFailures returns how many paths could not be watched.
+func (ws *watchSet) watchFailures() int {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.failures
+}
+
+// watchCount returns how many watches are registered, and how many more
+// would have been needed to stay under --max-watches.
+func (ws *watchSet) watchCount() (registered, refused int) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return len(ws.watched), ws.overLimit
+}
+
+// empty reports whether nothing at all is being watched.
+func (ws *watchSet) empty() bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return len(ws.explicit) == 0 && len(ws.globs) == 0 && len(ws.dirs) == 0 && len(ws.roots) == 0 && len(ws.pending) == 0
+}
+
+// walkFiles calls fn for every existing file covered by ws.
+func (ws *watchSet) walkFiles(fn func(path string)) {
+	for _, p := range ws.list() {
+		if !isDir(p) {
+			fn(p)
+			continue
+		}
+		filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() {
+				if path != p && !ws.recursive {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if ws.relevant(path) {
+				fn(path)
+			}
+			return nil
+		})
+	}
+}
+
+// list returns the watched files and directories.
+func (ws *watchSet) list() []string {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	seen := map[string]bool{}
+	for _, set := range []map[string]bool{ws.explicit, ws.matched, ws.dirs, ws.pending} {
+		for p := range set {
+			seen[p] = true
+		}
+	}
+	for _, r := range ws.roots {
+		seen[r] = true
+	}
+	out := make([]string, 0, len(seen))
+	for p := range seen {
+		out = append(out, p)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// relevant reports whether an event on name should trigger the command.
+func (ws *watchSet) relevant(name string) bool {
+	return ws.irrelevant(name) == ""
+}
+
+// irrelevant returns why an event on name should not trigger the
+// command, or "" if it should.
+func (ws *watchSet) irrelevant(name string) string {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if reason := ws.filter.rejects(name); reason != "" {
+		return reason
+	}
+	if ws.explicit[name] || ws.dirs[name] || ws.dirs[filepath.Dir(name)] {
+		return ""
+	}
+	if ws.matchesGlob(name) {
+		return ""
+	}
+	for _, root := range ws.roots {
+		if within(root, name) {
+			switch {
+			case ws.hiddenBelow(root, name):
+				return "hidden, see --hidden"
+			case ws.tooDeep(root, name, false):
+				return "deeper than --max-depth"
+			case ws.gitignored(name, isDir(name)):
+				return "ignored by .gitignore"
+			}
+			return ""
+		}
+	}
+	return "not a watched path"
+}
+
+// hiddenBelow reports whether name is a dotfile, or inside a dot
+// directory, below root and hidden paths are being skipped.
+func (ws *watchSet) hiddenBelow(root, name string) bool {
+	if ws.hidden {
+		return false
+	}
+	rel, err := filepath.Rel(root, name)
+	if err != nil || rel == "." {
+		return false
+	}
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if isHidden(part) {
+			return true
+		}
+	}
+	return false
+}
+
+// tooDeep reports whether name is beyond --max-depth below root. Like
+// find's -maxdepth, depth 1 are the direct children of root, so
+// directories are only watched while their children are within reach.
+func (ws *watchSet) tooDeep(root, name string, dir bool) bool {
+	if ws.maxDepth <= 0 {
+		return false
+	}
+	rel, err := filepath.Rel(root, name)
+	if err != nil || rel == "." {
+		return false
+	}
+	depth := len(strings.Split(rel, string(filepath.Separator)))
+	if dir {
+		return depth >= ws.maxDepth
+	}
+	return depth > ws.maxDepth
+}
+
+// rootOf returns the recursive root name belongs to.
+func (ws *watchSet) rootOf(name string) string {
+	for _, root := range ws.roots {
+		if within(root, name) {
+			return root
+		}
+	}
+	return name
+}
+
+// gitignored reports whether name is excluded by a .gitignore.
+func (ws *watchSet) gitignored(name string, dir bool) bool {
+	return ws.ignore != nil && ws.ignore.ignored(name, dir)
+}
+
+func (ws *watchSet) matchesGlob(name string) bool {
+	for _, g := range ws.globs {
+		if strings.Contains(g, "**") {
+			if matchDoublestar(filepath.ToSlash(g), filepath.ToSlash(name)) {
+				return true
+			}
+			continue
+		}
+		if ok, _ := filepath.Match(g, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// update keeps the watches in sync with the filesystem: new directories
+// in recursive roots get watched, new files matching a glob are tracked
+// and explicitly named files are re-added after being replaced. It
+// returns the awaited paths that appeared because of this event.
+func (ws *watchSet) update(event fsnotify.Event) (appeared []string) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if event.Op&fsnotify.Create == fsnotify.Create {
+		for p := range ws.pending {
+			if within(event.Name, p) && ws.resolvePending(p) {
+				appeared = append(appeared, p)
+			}
+		}
+
+		if isDir(event.Name) {
+			// fsnotify does not recurse by itself
+			for _, root := range ws.roots {
+				if within(root, event.Name) && !ws.hiddenBelow(root, event.Name) && !ws.tooDeep(root, event.Name, true) && !ws.gitignored(event.Name, true) && !ws.filter.excluded(event.Name) {
+					ws.addTree(event.Name)
+					break
+				}
+			}
+			for _, base := range ws.deep {
+				if within(base, event.Name) && !ws.hiddenBelow(base, event.Name) && !ws.filter.excluded(event.Name) {
+					ws.addTree(event.Name)
+					break
+				}
+			}
+		} else if ws.matchesGlob(event.Name) && !ws.filter.excluded(event.Name) {
+			ws.matched[event.Name] = true
+		}
+	}
+
+	if _, ok := ws.links[event.Name]; ok {
+		ws.relink(event.Name)
+	}
+
+	if ws.ignore != nil && filepath.Base(event.Name) == ".gitignore" {
+		ws.ignore.loadDir(filepath.Dir(event.Name))
+	}
+
+	if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		delete(ws.matched, event.Name)
+		// the kernel drops the watch along with the path
+		delete(ws.watched, event.Name)
+
+		// Files are watched through their directory, only when that
+		// goes away do they need to be waited for
+		for f := range ws.explicit {
+			if f != event.Name && within(event.Name, f) {
+				ws.addPending(f)
+			}
+		}
+	}
+	return appeared
+}
+
+// addTree registers a watch on root and every directory below it,
+// skipping ignored directories. It returns the number of directories
+// that were added.
+func (ws *watchSet) addTree(root string) int {
+	// WalkDir does not follow a symlinked root, so walk its target and
+	// register the paths under the link's name.
+	walkRoot := root
+	if target, err := filepath.EvalSymlinks(root); err == nil && ws.follow {
+		walkRoot = target
+	}
+
+	added := 0
+	filepath.WalkDir(walkRoot, func(path string, d fs.DirEntry, err error) error {
+		if walkRoot != root {
+			rel, _ := filepath.Rel(walkRoot, path)
+			path = filepath.Join(root, rel)
+		}
+		if err != nil {
+			warnf("Warning: Cannot walk '%s': %v\n", path, err)
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root && (ws.filter.excluded(path) || ws.gitignored(path, true) || !ws.hidden && isHidden(d.Name())) {
+			return filepath.SkipDir
+		}
+		if ws.tooDeep(ws.rootOf(path), path, true) {
+			return filepath.SkipDir
+		}
+		if ws.ignore != nil {
+			ws.ignore.loadDir(path)
+		}
+		if err := ws.watch(path); err != nil {
+			warnf("Error watching '%s': %v\n", path, err)
+			return nil
+		}
+		added++
+		return nil
+	})
+	return added
+}
+
+// newerThan reports whether target is missing or any file covered by ws
+// was modified after it, like make deciding whether to rebuild.
+func (ws *watchSet) newerThan(target string) bool {
+	info, err := os.Stat(target)
+	if err != nil {
+		return true
+	}
+	stale := false
+	ws.walkFiles(func(path string) {
+		if fi, err := os.Stat(path); err == nil && fi.ModTime().After(info.ModTime()) {
+			stale = true
+		}
+	})
+	return stale
+}
+
+// staticPrefix returns the leading directories of pattern that contain
+// no glob characters.
+func staticPrefix(pattern string) string {
+	sep := string(filepath.Separator)
+	parts := strings.Split(pattern, sep)
+	i := 0
+	for i < len(parts) && !hasMeta(parts[i]) {
+		i++
+	}
+	prefix := strings.Join(parts[:i], sep)
+	if prefix == "" {
+		if strings.HasPrefix(pattern, sep) {
+			return sep
+		}
+		return "."
+	}
+	return prefix
+}
+
+// existingAncestor returns the closest directory above name that exists.
+func existingAncestor(name string) string {
+	dir := filepath.Dir(name)
+	for !isDir(dir) && dir != filepath.Dir(dir) {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// isHidden reports whether a file name is a dotfile.
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".") && name != "." && name != ".."
+}
+
+// isDir reports whether path exists and is a directory.
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// hasMeta reports whether path contains any of the magic characters
+// recognized by filepath.Match.
+func hasMeta(path string) bool {
+	magicChars := `*?[`
+	if runtime.GOOS != "windows" {
+		magicChars = `*?[\`
+	}
+	return strings.ContainsAny(path, magicChars)
+}
+
+// within reports whether path is root or somewhere below it.
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
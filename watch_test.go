@@ -0,0 +1,151 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fakeNotifier records the paths being watched instead of watching them.
+type fakeNotifier struct {
+	added map[string]bool
+}
+
+func newFakeNotifier() *fakeNotifier {
+	return &fakeNotifier{added: map[string]bool{}}
+}
+
+func (n *fakeNotifier) Add(name string) error {
+	n.added[name] = true
+	return nil
+}
+
+func (n *fakeNotifier) Remove(name string) error {
+	delete(n.added, name)
+	return nil
+}
+
+func (n *fakeNotifier) Close() error                  { return nil }
+func (n *fakeNotifier) events() <-chan fsnotify.Event { return nil }
+func (n *fakeNotifier) errors() <-chan error          { return nil }
+
+// writeFiles creates the files below dir, along with their directories.
+func writeFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWatchSetUpdateGlob(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, "a.go")
+	ws := newWatchSet(newFakeNotifier(), newOptions())
+	if err := ws.add(filepath.Join(dir, "*.go")); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFiles(t, dir, "b.go", "c.txt")
+	for _, name := range []string{"b.go", "c.txt"} {
+		ws.update(fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Create})
+	}
+	want := []string{filepath.Join(dir, "a.go"), filepath.Join(dir, "b.go")}
+	if got := ws.list(); !slices.Equal(got, want) {
+		t.Errorf("after creating b.go and c.txt: list() = %q, want %q", got, want)
+	}
+
+	ws.update(fsnotify.Event{Name: filepath.Join(dir, "a.go"), Op: fsnotify.Remove})
+	want = want[1:]
+	if got := ws.list(); !slices.Equal(got, want) {
+		t.Errorf("after removing a.go: list() = %q, want %q", got, want)
+	}
+}
+
+func TestWatchSetUpdateRecursive(t *testing.T) {
+	dir := t.TempDir()
+	opts := newOptions()
+	opts.recursive = true
+	n := newFakeNotifier()
+	ws := newWatchSet(n, opts)
+	if err := ws.add(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFiles(t, dir, "sub/deeper/x.go", ".cache/x")
+	ws.update(fsnotify.Event{Name: filepath.Join(dir, "sub"), Op: fsnotify.Create})
+	ws.update(fsnotify.Event{Name: filepath.Join(dir, ".cache"), Op: fsnotify.Create})
+	for name, want := range map[string]bool{"sub": true, "sub/deeper": true, ".cache": false} {
+		if got := n.added[filepath.Join(dir, name)]; got != want {
+			t.Errorf("%s watched: %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestWatchSetUpdatePending(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "later", "out.txt")
+	ws := newWatchSet(newFakeNotifier(), newOptions())
+	if err := ws.add(file); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFiles(t, dir, "later/out.txt")
+	appeared := ws.update(fsnotify.Event{Name: filepath.Join(dir, "later"), Op: fsnotify.Create})
+	if !slices.Equal(appeared, []string{file}) {
+		t.Fatalf("update() = %q, want %q", appeared, []string{file})
+	}
+	if !ws.relevant(file) {
+		t.Errorf("%s is not relevant once it appeared", file)
+	}
+
+	// Only the directory going away makes it pending again
+	if appeared := ws.update(fsnotify.Event{Name: filepath.Join(dir, "later"), Op: fsnotify.Remove}); appeared != nil {
+		t.Errorf("update() after removing the directory = %q, want nothing", appeared)
+	}
+	if !ws.pending[file] {
+		t.Errorf("%s is not awaited after its directory was removed", file)
+	}
+}
+
+func TestWatchSetIrrelevant(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, "a.go", "sub/b.go", "sub/deep/c.go", ".env", "build/out.o", "x.tmp", ".gitignore")
+	if err := os.WriteFile(filepath.Join(dir, ".gitignore"), []byte("build/\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts := newOptions()
+	opts.recursive = true
+	opts.maxDepth = 2
+	opts.excludes = stringList{"*.tmp"}
+	ws := newWatchSet(newFakeNotifier(), opts)
+	if err := ws.add(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, want string
+	}{
+		{"a.go", ""},
+		{"sub/b.go", ""},
+		{"sub/deep/c.go", "deeper than --max-depth"},
+		{".env", "hidden, see --hidden"},
+		{"build/out.o", "ignored by .gitignore"},
+		{"x.tmp", "excluded"},
+		{"a.go.swp", "excluded"},
+		{"../elsewhere.go", "not a watched path"},
+	}
+	for _, tt := range tests {
+		if got := ws.irrelevant(filepath.Join(dir, tt.name)); got != tt.want {
+			t.Errorf("irrelevant(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+const webhookAttempts = 3
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// postWebhooks POSTs payload as JSON to every --webhook URL in the
+// background. Failed deliveries are retried with a growing delay.
+func postWebhooks(urls []string, payload fields) {
+	if len(urls) == 0 {
+		return
+	}
+	hostname, _ := os.Hostname()
+	payload["hostname"] = hostname
+	payload["time"] = time.Now().Format(time.RFC3339Nano)
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	for _, url := range urls {
+		deliverWebhook(url, body)
+	}
+}
+
+// deliverWebhook POSTs body to url in the background, retrying with a
+// growing delay.
+func deliverWebhook(url string, body []byte) {
+	go func() {
+		var err error
+		for attempt := 1; attempt <= webhookAttempts; attempt++ {
+			if err = postWebhook(url, body); err == nil {
+				return
+			}
+			debugf("webhook %s, attempt %d: %v", url, attempt, err)
+			if attempt < webhookAttempts {
+				time.Sleep(time.Duration(attempt) * time.Second)
+			}
+		}
+		warnf("Warning: webhook %s: %v\n", url, err)
+	}()
+}
+
+func postWebhook(url string, body []byte) error {
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}